@@ -1,25 +1,194 @@
 package collector
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/metrics"
+
 	"github.com/aporeto-inc/trireme-statistics/influxdb"
 	"go.aporeto.io/trireme-lib/collector"
 	"go.uber.org/zap"
 )
 
+const (
+	// influxDBSink names the InfluxDB collector in the metrics.
+	influxDBSink = "influxdb"
+	// influxDBInitialBackoff is the delay before the first reconnection to InfluxDB. It is doubled at each attempt.
+	influxDBInitialBackoff = time.Second
+	// influxDBMaxBackoff caps the delay between two reconnections to InfluxDB.
+	influxDBMaxBackoff = time.Minute
+)
+
 // NewDefaultCollector returns an empty collectorInstance
 func NewDefaultCollector() collector.EventCollector {
 	zap.L().Info("Using default empty collector")
 	return &collector.DefaultCollector{}
 }
 
-// NewInfluxDBCollector returns a collector implementation for InfluxDB
-func NewInfluxDBCollector(user, pass, url, db string, insecureSkipVerify bool) collector.EventCollector {
+// NewInfluxDBCollector returns a collector implementation for InfluxDB.
+// If InfluxDB can't be reached, an error is returned when failFast is set. Otherwise the collector
+// reconnects in the background and spools at most spoolSize events until it is connected.
+func NewInfluxDBCollector(user, pass, url, db string, insecureSkipVerify bool, spoolSize int, failFast bool) (collector.EventCollector, error) {
 	zap.L().Info("Using Influx collector", zap.String("endpoint", url), zap.String("user", user))
-	collectorInstance, err := influxdb.NewDBConnection(user, pass, url, db, insecureSkipVerify)
+	connect := func() (collector.EventCollector, error) {
+		collectorInstance, err := influxdb.NewDBConnection(user, pass, url, db, insecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		collectorInstance.Start()
+		return collectorInstance, nil
+	}
+
+	c := newReconnectingCollector(influxDBSink, connect, spoolSize)
+	err := c.connect()
+	if err == nil {
+		return c, nil
+	}
+	if failFast {
+		return nil, fmt.Errorf("InfluxDB collector unavailable: %s", err)
+	}
+
+	zap.L().Error("Error instantiating Influx collector, reconnecting in the background", zap.String("endpoint", url), zap.String("user", user), zap.Error(err))
+	go c.reconnect()
+	return c, nil
+}
+
+// reconnectingCollector forwards the events to a backend collector once connected to it.
+// The events collected while disconnected are spooled and replayed on connection.
+type reconnectingCollector struct {
+	name       string
+	newBackend func() (collector.EventCollector, error)
+	spoolSize  int
+	backoff    time.Duration
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	sync.RWMutex
+	backend collector.EventCollector
+	spool   []func(collector.EventCollector)
+	err     error
+}
+
+func newReconnectingCollector(name string, newBackend func() (collector.EventCollector, error), spoolSize int) *reconnectingCollector {
+	metrics.CollectorConnected.WithLabelValues(name).Set(0)
+	return &reconnectingCollector{
+		name:       name,
+		newBackend: newBackend,
+		spoolSize:  spoolSize,
+		backoff:    influxDBInitialBackoff,
+		stop:       make(chan struct{}),
+		err:        fmt.Errorf("%s collector not connected", name),
+	}
+}
+
+// CollectFlowEvent forwards the flow, or spools it while disconnected.
+func (c *reconnectingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.collect(func(backend collector.EventCollector) { backend.CollectFlowEvent(record) })
+}
+
+// CollectContainerEvent forwards the container event, or spools it while disconnected.
+func (c *reconnectingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.collect(func(backend collector.EventCollector) { backend.CollectContainerEvent(record) })
+}
+
+// Check returns an error while the collector is disconnected.
+func (c *reconnectingCollector) Check() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.err
+}
+
+// Close stops the reconnection.
+func (c *reconnectingCollector) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+// collect forwards the event to the backend outside of the lock, so that a slow backend doesn't block Check.
+func (c *reconnectingCollector) collect(event func(collector.EventCollector)) {
+	c.Lock()
+	backend := c.backend
+	if backend == nil {
+		c.spoolEvent(event)
+	}
+	c.Unlock()
+
+	if backend != nil {
+		event(backend)
+	}
+}
+
+// spoolEvent spools the event until the backend is connected. The oldest events are dropped once the spool is full.
+// It is called with the lock held.
+func (c *reconnectingCollector) spoolEvent(event func(collector.EventCollector)) {
+	if c.spoolSize < 1 {
+		metrics.CollectorDroppedEvents.WithLabelValues(c.name).Inc()
+		return
+	}
+	if len(c.spool) >= c.spoolSize {
+		c.spool = c.spool[1:]
+		metrics.CollectorDroppedEvents.WithLabelValues(c.name).Inc()
+	}
+	c.spool = append(c.spool, event)
+}
+
+// connect creates the backend and replays the spooled events to it. The events are replayed outside of
+// the lock: the events collected meanwhile are spooled, and replayed next, until the spool is empty.
+func (c *reconnectingCollector) connect() error {
+	backend, err := c.newBackend()
 	if err != nil {
-		zap.L().Error("Error instantiating Influx collector, using default", zap.String("endpoint", url), zap.String("user", user), zap.Error(err))
-		return NewDefaultCollector()
+		return err
+	}
+
+	replayed := 0
+	for {
+		c.Lock()
+		spool := c.spool
+		c.spool = nil
+		if len(spool) == 0 {
+			c.backend = backend
+			c.err = nil
+			c.Unlock()
+			break
+		}
+		c.Unlock()
+
+		for _, event := range spool {
+			event(backend)
+		}
+		replayed += len(spool)
+	}
+
+	zap.L().Info("Collector connected", zap.String("sink", c.name), zap.Int("replayed", replayed))
+	metrics.CollectorConnected.WithLabelValues(c.name).Set(1)
+	return nil
+}
+
+// reconnect retries to connect with an exponential backoff until it succeeds or the collector is closed.
+func (c *reconnectingCollector) reconnect() {
+	backoff := c.backoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-c.stop:
+			return
+		}
+
+		err := c.connect()
+		if err == nil {
+			return
+		}
+		zap.L().Warn("Unable to connect collector", zap.String("sink", c.name), zap.Duration("backoff", backoff), zap.Error(err))
+
+		c.Lock()
+		c.err = fmt.Errorf("%s collector not connected: %s", c.name, err)
+		c.Unlock()
+
+		backoff *= 2
+		if backoff > influxDBMaxBackoff {
+			backoff = influxDBMaxBackoff
+		}
 	}
-	collectorInstance.Start()
-	return collectorInstance
 }
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestReconnectingCollector(t *testing.T) {
+	backend := &testSink{blocked: make(chan struct{})}
+	close(backend.blocked)
+	attempts := 0
+	connected := make(chan struct{})
+	newBackend := func() (collector.EventCollector, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		close(connected)
+		return backend, nil
+	}
+
+	c := newReconnectingCollector("test", newBackend, 2)
+	c.backoff = time.Millisecond
+	defer c.Close()
+	if err := c.connect(); err == nil {
+		t.Fatalf("Expected a connection error")
+	}
+
+	// The oldest event is dropped from the full spool.
+	for i := 0; i < 3; i++ {
+		c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	}
+	if err := c.Check(); err == nil {
+		t.Errorf("Expected the collector to be reported as disconnected")
+	}
+
+	go c.reconnect()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Collector not reconnected")
+	}
+
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	if err := c.Check(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	backend.Lock()
+	defer backend.Unlock()
+	if backend.flows != 3 {
+		t.Errorf("Expected 2 replayed flows and 1 forwarded flow, got %d", backend.flows)
+	}
+}
+
+func TestReconnectingCollectorSlowBackend(t *testing.T) {
+	backend := &testSink{blocked: make(chan struct{})}
+	c := newReconnectingCollector("test", func() (collector.EventCollector, error) { return backend, nil }, 2)
+	defer c.Close()
+	if err := c.connect(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The backend is blocked while forwarding the event.
+	forwarded := make(chan struct{})
+	go func() {
+		c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+		close(forwarded)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	checked := make(chan error)
+	go func() { checked <- c.Check() }()
+	select {
+	case err := <-checked:
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Check blocked by the backend")
+	}
+
+	close(backend.blocked)
+	<-forwarded
+}
@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-kubernetes/health"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+	api "k8s.io/api/core/v1"
+)
+
+// Tags added to the flow records by the enrichment collector. The source and destination tags
+// are prefixed by SourceTagPrefix and DestinationTagPrefix.
+const (
+	SourceTagPrefix      = "k8s:src:"
+	DestinationTagPrefix = "k8s:dst:"
+
+	NamespaceTag = "namespace"
+	PodTag       = "pod"
+	// WorkloadTag is the kind and name of the controller owning the pod, ex: Deployment/web.
+	WorkloadTag = "workload"
+	// ServiceTag is set once per Service selecting the pod.
+	ServiceTag = "service"
+	NodeTag    = "node"
+)
+
+// PodResolver resolves the flow endpoints into Kubernetes pods.
+type PodResolver interface {
+	// PodByContextID returns the pod of a PU.
+	PodByContextID(contextID string) (*api.Pod, error)
+	// PodByIP returns the pod having the given IP.
+	PodByIP(ip string) (*api.Pod, error)
+	// PodServices returns the names of the Services selecting the pod.
+	PodServices(pod *api.Pod) ([]string, error)
+	// PodWorkload returns the kind and name of the controller owning the pod, ex: Deployment/web.
+	PodWorkload(pod *api.Pod) (string, error)
+}
+
+// PodEnricher adds the Kubernetes metadata of the source and destination pods to the tags of the flow records.
+// It is shared by the enrichment collectors of all the sinks.
+type PodEnricher struct {
+	sync.RWMutex
+	resolver PodResolver
+}
+
+// NewPodEnricher returns a PodEnricher. The flows are not enriched until a PodResolver is set.
+func NewPodEnricher() *PodEnricher {
+	return &PodEnricher{}
+}
+
+// SetPodResolver sets the PodResolver used to resolve the flow endpoints.
+func (e *PodEnricher) SetPodResolver(resolver PodResolver) {
+	e.Lock()
+	defer e.Unlock()
+	e.resolver = resolver
+}
+
+// enrich returns a copy of the record with the enriched tags. The record itself is shared with the
+// other sinks and is never modified.
+func (e *PodEnricher) enrich(record *collector.FlowRecord) *collector.FlowRecord {
+	e.RLock()
+	resolver := e.resolver
+	e.RUnlock()
+	if resolver == nil {
+		return record
+	}
+
+	tags := policy.NewTagStore()
+	if record.Tags != nil {
+		tags = record.Tags.Copy()
+	}
+	enrichEndpoint(resolver, tags, SourceTagPrefix, record.Source)
+	enrichEndpoint(resolver, tags, DestinationTagPrefix, record.Destination)
+
+	enriched := *record
+	enriched.Tags = tags
+	return &enriched
+}
+
+// enrichmentCollector enriches the flows before forwarding them to the wrapped collector.
+type enrichmentCollector struct {
+	collector.EventCollector
+	enricher *PodEnricher
+}
+
+// NewEnrichmentCollector returns a collector enriching the flows forwarded to next with enricher.
+// It is meant to wrap the sinks of a fan-out collector, so that the flows are enriched from the
+// goroutine of each sink instead of the datapath.
+func NewEnrichmentCollector(enricher *PodEnricher, next collector.EventCollector) collector.EventCollector {
+	return &enrichmentCollector{
+		EventCollector: next,
+		enricher:       enricher,
+	}
+}
+
+// CollectFlowEvent enriches the flow and forwards it.
+func (c *enrichmentCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.EventCollector.CollectFlowEvent(c.enricher.enrich(record))
+}
+
+// Check reports the health of the wrapped collector.
+func (c *enrichmentCollector) Check() error {
+	if checker, ok := c.EventCollector.(health.Checker); ok {
+		return checker.Check()
+	}
+	return nil
+}
+
+// Close closes the wrapped collector.
+func (c *enrichmentCollector) Close() error {
+	if closer, ok := c.EventCollector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// enrichEndpoint adds the metadata of the pod of the endpoint to tags. The endpoint is resolved as a PU
+// of the node first, then by its IP for the pods of the other nodes.
+func enrichEndpoint(resolver PodResolver, tags *policy.TagStore, prefix string, endpoint *collector.EndPoint) {
+	if endpoint == nil {
+		return
+	}
+	pod, err := resolver.PodByContextID(endpoint.ID)
+	if err != nil {
+		if endpoint.IP == "" {
+			return
+		}
+		if pod, err = resolver.PodByIP(endpoint.IP); err != nil {
+			return
+		}
+	}
+
+	tags.AppendKeyValue(prefix+NamespaceTag, pod.GetNamespace())
+	tags.AppendKeyValue(prefix+PodTag, pod.GetName())
+	if workload, err := resolver.PodWorkload(pod); err == nil && workload != "" {
+		tags.AppendKeyValue(prefix+WorkloadTag, workload)
+	}
+	if pod.Spec.NodeName != "" {
+		tags.AppendKeyValue(prefix+NodeTag, pod.Spec.NodeName)
+	}
+	services, err := resolver.PodServices(pod)
+	if err != nil {
+		return
+	}
+	for _, service := range services {
+		tags.AppendKeyValue(prefix+ServiceTag, service)
+	}
+}
+
+// enrichedEndpoint returns the pod of the endpoint from the tags added by the enrichment collector,
+// or from pus if the flow wasn't enriched.
+func enrichedEndpoint(pus *puCache, tags *policy.TagStore, prefix string, endpoint *collector.EndPoint) puMetadata {
+	if tags == nil {
+		return pus.endpoint(endpoint)
+	}
+	pu := puMetadata{}
+	services := []string{}
+	for _, tag := range tags.GetSlice() {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(tag, prefix), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case NamespaceTag:
+			pu.namespace = kv[1]
+		case PodTag:
+			pu.pod = kv[1]
+		case WorkloadTag:
+			pu.workload = kv[1]
+		case NodeTag:
+			pu.node = kv[1]
+		case ServiceTag:
+			services = append(services, kv[1])
+		}
+	}
+	if pu.namespace == "" {
+		return pus.endpoint(endpoint)
+	}
+	pu.services = strings.Join(services, ",")
+	return pu
+}
+
+// flowEndpoints returns the pods of the source and destination of the flow.
+func flowEndpoints(pus *puCache, record *collector.FlowRecord) (puMetadata, puMetadata) {
+	return enrichedEndpoint(pus, record.Tags, SourceTagPrefix, record.Source), enrichedEndpoint(pus, record.Tags, DestinationTagPrefix, record.Destination)
+}
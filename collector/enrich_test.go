@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testPodResolver resolves the pods of contextIDs and IPs from maps.
+type testPodResolver struct {
+	byContextID map[string]*api.Pod
+	byIP        map[string]*api.Pod
+}
+
+func (r *testPodResolver) PodByContextID(contextID string) (*api.Pod, error) {
+	if pod, ok := r.byContextID[contextID]; ok {
+		return pod, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (r *testPodResolver) PodByIP(ip string) (*api.Pod, error) {
+	if pod, ok := r.byIP[ip]; ok {
+		return pod, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (r *testPodResolver) PodServices(pod *api.Pod) ([]string, error) {
+	if pod.GetName() == "web-0" {
+		return []string{"web", "web-headless"}, nil
+	}
+	return []string{}, nil
+}
+
+func (r *testPodResolver) PodWorkload(pod *api.Pod) (string, error) {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return owner.Kind + "/" + owner.Name, nil
+	}
+	return "", nil
+}
+
+// recordingCollector keeps the last flow it received.
+type recordingCollector struct {
+	collector.DefaultCollector
+	flow   *collector.FlowRecord
+	closed bool
+}
+
+func (c *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flow = record
+}
+
+func (c *recordingCollector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestEnrichmentCollector(t *testing.T) {
+	controller := true
+	web := &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-0",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "web", Controller: &controller}},
+		},
+		Spec: api.PodSpec{NodeName: "node-1"},
+	}
+	db := &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "payments"},
+		Spec:       api.PodSpec{NodeName: "node-2"},
+	}
+
+	next := &recordingCollector{}
+	enricher := NewPodEnricher()
+	c := NewEnrichmentCollector(enricher, next)
+	record := &collector.FlowRecord{
+		Source:      &collector.EndPoint{ID: "abc", IP: "10.0.0.1"},
+		Destination: &collector.EndPoint{ID: "10.0.0.2", IP: "10.0.0.2"},
+	}
+
+	// Flows are forwarded as is until the resolver is set.
+	c.CollectFlowEvent(record)
+	if next.flow.Tags != nil {
+		t.Errorf("Expected the flow not to be enriched, got %v", next.flow.Tags)
+	}
+
+	tags := policy.NewTagStore()
+	record.Tags = tags
+	enricher.SetPodResolver(&testPodResolver{
+		byContextID: map[string]*api.Pod{"abc": web},
+		byIP:        map[string]*api.Pod{"10.0.0.2": db},
+	})
+	c.CollectFlowEvent(record)
+	if len(tags.GetSlice()) != 0 || record.Tags != tags {
+		t.Errorf("Expected the original record not to be modified, got %v", record.Tags.GetSlice())
+	}
+	if next.flow == record {
+		t.Errorf("Expected a copy of the record to be forwarded")
+	}
+
+	src, dst := flowEndpoints(newPUCache(), next.flow)
+	expectedSrc := puMetadata{namespace: "default", pod: "web-0", workload: "StatefulSet/web", services: "web,web-headless", node: "node-1"}
+	expectedDst := puMetadata{namespace: "payments", pod: "db-0", node: "node-2"}
+	if !reflect.DeepEqual(src, expectedSrc) {
+		t.Errorf("Expected source %v, got %v", expectedSrc, src)
+	}
+	if !reflect.DeepEqual(dst, expectedDst) {
+		t.Errorf("Expected destination %v, got %v", expectedDst, dst)
+	}
+}
+
+func TestEnrichmentCollectorInFanout(t *testing.T) {
+	enricher := NewPodEnricher()
+	enricher.SetPodResolver(&testPodResolver{
+		byContextID: map[string]*api.Pod{"abc": {ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default"}}},
+	})
+
+	sinks := []*recordingCollector{{}, {}}
+	c, err := NewFanoutCollector(10,
+		Sink{Name: "a", Collector: NewEnrichmentCollector(enricher, sinks[0])},
+		Sink{Name: "b", Collector: NewEnrichmentCollector(enricher, sinks[1])},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	record := &collector.FlowRecord{Source: &collector.EndPoint{ID: "abc"}, Destination: &collector.EndPoint{ID: "def"}}
+	c.CollectFlowEvent(record)
+	if err := c.(io.Closer).Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if record.Tags != nil {
+		t.Errorf("Expected the collected record not to be modified, got %v", record.Tags.GetSlice())
+	}
+	for i, sink := range sinks {
+		if !sink.closed {
+			t.Errorf("Sink %d: expected the sink to be closed through the enrichment collector", i)
+		}
+		if sink.flow == nil {
+			t.Fatalf("Sink %d: expected a flow", i)
+		}
+		if src, _ := flowEndpoints(newPUCache(), sink.flow); src.pod != "web-0" {
+			t.Errorf("Sink %d: expected the flow to be enriched, got %v", i, src)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-kubernetes/health"
+	"github.com/aporeto-inc/trireme-kubernetes/metrics"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// Sink is a named collector receiving the events of a fan-out collector.
+type Sink struct {
+	Name      string
+	Collector collector.EventCollector
+}
+
+// sinkQueue forwards the events queued for a sink from its own goroutine.
+type sinkQueue struct {
+	Sink
+	events chan func()
+}
+
+// fanoutCollector dispatches every event to several sinks.
+type fanoutCollector struct {
+	queues  []*sinkQueue
+	running sync.WaitGroup
+	close   sync.Once
+
+	// closed is set once the queues are closed. It is locked for writing only by Close.
+	sync.RWMutex
+	closed bool
+}
+
+// NewFanoutCollector returns a collector forwarding every event to each sink. Each sink is fed from its own
+// queue of queueSize events, so that a slow or failing sink never blocks the enforcer: the events of a sink
+// are dropped while its queue is full.
+func NewFanoutCollector(queueSize int, sinks ...Sink) (collector.EventCollector, error) {
+	if queueSize < 1 {
+		return nil, fmt.Errorf("Invalid collector queue size: %d", queueSize)
+	}
+
+	c := &fanoutCollector{}
+	for _, sink := range sinks {
+		queue := &sinkQueue{
+			Sink:   sink,
+			events: make(chan func(), queueSize),
+		}
+		c.queues = append(c.queues, queue)
+
+		c.running.Add(1)
+		go func() {
+			defer c.running.Done()
+			for event := range queue.events {
+				event()
+			}
+		}()
+	}
+	return c, nil
+}
+
+// CollectFlowEvent queues the flow for each sink.
+func (c *fanoutCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	for _, queue := range c.queues {
+		sink := queue.Collector
+		c.enqueue(queue, func() { sink.CollectFlowEvent(record) })
+	}
+}
+
+// CollectContainerEvent queues the container event for each sink.
+func (c *fanoutCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	for _, queue := range c.queues {
+		sink := queue.Collector
+		c.enqueue(queue, func() { sink.CollectContainerEvent(record) })
+	}
+}
+
+// Check reports the sinks that are unhealthy.
+func (c *fanoutCollector) Check() error {
+	failures := []string{}
+	for _, queue := range c.queues {
+		checker, ok := queue.Collector.(health.Checker)
+		if !ok {
+			continue
+		}
+		if err := checker.Check(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", queue.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Collector sinks unhealthy: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// Close forwards the queued events, then closes the sinks. The events collected after Close are dropped.
+func (c *fanoutCollector) Close() error {
+	var err error
+	c.close.Do(func() {
+		c.Lock()
+		c.closed = true
+		for _, queue := range c.queues {
+			close(queue.events)
+		}
+		c.Unlock()
+		c.running.Wait()
+
+		for _, queue := range c.queues {
+			closer, ok := queue.Collector.(io.Closer)
+			if !ok {
+				continue
+			}
+			if cerr := closer.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("Couldn't close collector sink %s: %s", queue.Name, cerr)
+			}
+		}
+	})
+	return err
+}
+
+func (c *fanoutCollector) enqueue(queue *sinkQueue, event func()) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.closed {
+		zap.L().Debug("Collector closed, dropping event", zap.String("sink", queue.Name))
+		return
+	}
+
+	select {
+	case queue.events <- event:
+	default:
+		metrics.CollectorDroppedEvents.WithLabelValues(queue.Name).Inc()
+		zap.L().Debug("Collector sink queue full, dropping event", zap.String("sink", queue.Name))
+	}
+}
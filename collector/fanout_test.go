@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// testSink records the flows it receives. Each flow blocks until blocked is closed.
+type testSink struct {
+	collector.DefaultCollector
+	blocked chan struct{}
+	err     error
+
+	sync.Mutex
+	flows  int
+	closed bool
+}
+
+func (s *testSink) CollectFlowEvent(record *collector.FlowRecord) {
+	<-s.blocked
+	s.Lock()
+	defer s.Unlock()
+	s.flows++
+}
+
+func (s *testSink) Check() error {
+	return s.err
+}
+
+func (s *testSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestFanoutCollector(t *testing.T) {
+	fast := &testSink{blocked: make(chan struct{})}
+	close(fast.blocked)
+	slow := &testSink{blocked: make(chan struct{}), err: fmt.Errorf("unavailable")}
+
+	c, err := NewFanoutCollector(2, Sink{Name: "fast", Collector: fast}, Sink{Name: "slow", Collector: slow})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The slow sink takes one event and queues two, the others are dropped without blocking.
+	for i := 0; i < 4; i++ {
+		c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	}
+	if err := c.(*fanoutCollector).Check(); err == nil {
+		t.Errorf("Expected the slow sink to be reported")
+	}
+
+	close(slow.blocked)
+	if err := c.(*fanoutCollector).Close(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if fast.flows < 2 || !fast.closed {
+		t.Errorf("Expected the fast sink to receive the queued flows and be closed, got %d flows", fast.flows)
+	}
+	if slow.flows > 3 || slow.flows < 2 || !slow.closed {
+		t.Errorf("Expected the slow sink to receive at most 3 flows and be closed, got %d flows", slow.flows)
+	}
+}
+
+func TestFanoutCollectorAfterClose(t *testing.T) {
+	sink := &testSink{blocked: make(chan struct{})}
+	close(sink.blocked)
+
+	c, err := NewFanoutCollector(2, Sink{Name: "sink", Collector: sink})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := c.(*fanoutCollector).Close(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// The datapath and the monitor may still report events while shutting down.
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	c.CollectContainerEvent(&collector.ContainerRecord{})
+	if sink.flows != 0 {
+		t.Errorf("Expected the events collected after Close to be dropped, got %d flows", sink.flows)
+	}
+}
@@ -0,0 +1,168 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// flowLog is the JSON line written for each flow.
+type flowLog struct {
+	Timestamp            time.Time `json:"timestamp"`
+	Type                 string    `json:"type"`
+	SourceIP             string    `json:"src_ip"`
+	SourcePort           uint16    `json:"src_port"`
+	SourceNamespace      string    `json:"src_namespace"`
+	SourcePod            string    `json:"src_pod"`
+	SourceWorkload       string    `json:"src_workload,omitempty"`
+	SourceServices       []string  `json:"src_services,omitempty"`
+	SourceNode           string    `json:"src_node,omitempty"`
+	DestinationIP        string    `json:"dst_ip"`
+	DestinationPort      uint16    `json:"dst_port"`
+	DestinationNamespace string    `json:"dst_namespace"`
+	DestinationPod       string    `json:"dst_pod"`
+	DestinationWorkload  string    `json:"dst_workload,omitempty"`
+	DestinationServices  []string  `json:"dst_services,omitempty"`
+	DestinationNode      string    `json:"dst_node,omitempty"`
+	Action               string    `json:"action"`
+	PolicyID             string    `json:"policy_id"`
+}
+
+// containerLog is the JSON line written for each container event.
+type containerLog struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	ContextID string    `json:"context_id"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Event     string    `json:"event"`
+}
+
+// newFlowLog returns the JSON line of the flow.
+func newFlowLog(pus *puCache, record *collector.FlowRecord, timestamp time.Time) *flowLog {
+	src, dst := flowEndpoints(pus, record)
+	line := &flowLog{
+		Timestamp:            timestamp,
+		Type:                 "flow",
+		SourceNamespace:      src.namespace,
+		SourcePod:            src.pod,
+		SourceWorkload:       src.workload,
+		SourceServices:       splitServices(src.services),
+		SourceNode:           src.node,
+		DestinationNamespace: dst.namespace,
+		DestinationPod:       dst.pod,
+		DestinationWorkload:  dst.workload,
+		DestinationServices:  splitServices(dst.services),
+		DestinationNode:      dst.node,
+		Action:               flowAction(record),
+		PolicyID:             flowPolicyID(record),
+	}
+	if record.Source != nil {
+		line.SourceIP = record.Source.IP
+		line.SourcePort = record.Source.Port
+	}
+	if record.Destination != nil {
+		line.DestinationIP = record.Destination.IP
+		line.DestinationPort = record.Destination.Port
+	}
+	return line
+}
+
+// splitServices returns the services of puMetadata as a slice.
+func splitServices(services string) []string {
+	if services == "" {
+		return nil
+	}
+	return strings.Split(services, ",")
+}
+
+// newContainerLog returns the JSON line of the container event and keeps track of the pod of the PU in pus.
+func newContainerLog(pus *puCache, record *collector.ContainerRecord, timestamp time.Time) *containerLog {
+	pu := pus.container(record)
+	return &containerLog{
+		Timestamp: timestamp,
+		Type:      "container",
+		ContextID: record.ContextID,
+		Namespace: pu.namespace,
+		Pod:       pu.pod,
+		Event:     record.Event,
+	}
+}
+
+// fileCollector writes the flow and container events as JSON lines to a rotating file.
+type fileCollector struct {
+	collector.DefaultCollector
+
+	file *rotatingFile
+	pus  *puCache
+	now  func() time.Time
+
+	sync.RWMutex
+	err error
+}
+
+// NewFileCollector returns a collector writing every flow and container event as a JSON line to the file at path.
+// The file is rotated once it reaches maxSize bytes or is older than maxAge, and maxBackups rotated files are kept.
+// Zero values disable the corresponding limit.
+func NewFileCollector(path string, maxSize int64, maxAge time.Duration, maxBackups int) (collector.EventCollector, error) {
+	zap.L().Info("Using file collector", zap.String("path", path), zap.Int64("maxSize", maxSize), zap.Duration("maxAge", maxAge), zap.Int("maxBackups", maxBackups))
+	if path == "" {
+		return nil, fmt.Errorf("No file path given")
+	}
+
+	file, err := newRotatingFile(path, maxSize, maxAge, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &fileCollector{
+		file: file,
+		pus:  newPUCache(),
+		now:  time.Now,
+	}, nil
+}
+
+// CollectFlowEvent writes the flow.
+func (c *fileCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.write(newFlowLog(c.pus, record, c.now()))
+}
+
+// CollectContainerEvent writes the container event and keeps track of the pod of the PU.
+func (c *fileCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.write(newContainerLog(c.pus, record, c.now()))
+}
+
+// Check returns the error of the last write, if it failed.
+func (c *fileCollector) Check() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.err
+}
+
+// Close closes the file.
+func (c *fileCollector) Close() error {
+	return c.file.Close()
+}
+
+func (c *fileCollector) write(line interface{}) {
+	data, err := json.Marshal(line)
+	if err == nil {
+		_, err = c.file.Write(append(data, '\n'))
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if err == nil {
+		c.err = nil
+		return
+	}
+	// Only the first of consecutive failures is logged.
+	if c.err == nil {
+		zap.L().Error("Unable to write to the collector file", zap.String("path", c.file.path), zap.Error(err))
+	}
+	c.err = fmt.Errorf("Collector file unavailable: %s", err)
+}
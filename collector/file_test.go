@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestFileCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trireme-collector")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flows.log")
+	eventCollector, err := NewFileCollector(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	c := eventCollector.(*fileCollector)
+	c.now = func() time.Time { return time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue(resolver.UpstreamNamespaceIdentifier, "default")
+	tags.AppendKeyValue(resolver.UpstreamNameIdentifier, "web-0")
+	c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Tags: tags, Event: collector.ContainerStart})
+	c.CollectFlowEvent(&collector.FlowRecord{
+		Source:      &collector.EndPoint{ID: "10.0.0.1", IP: "10.0.0.1", Port: 34567},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80},
+		Action:      policy.Reject,
+		PolicyID:    "default",
+	})
+	if err := c.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{
+		`{"timestamp":"2018-01-01T00:00:00Z","type":"container","context_id":"abc","namespace":"default","pod":"web-0","event":"start"}`,
+		`{"timestamp":"2018-01-01T00:00:00Z","type":"flow","src_ip":"10.0.0.1","src_port":34567,"src_namespace":"external","src_pod":"external","dst_ip":"10.0.0.2","dst_port":80,"dst_namespace":"default","dst_pod":"web-0","action":"reject","policy_id":"default"}`,
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected lines\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	// Writes fail once the file is closed.
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	if err := c.Check(); err == nil {
+		t.Errorf("Expected a write error")
+	}
+}
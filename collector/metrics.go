@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"github.com/aporeto-inc/trireme-kubernetes/health"
+	"github.com/aporeto-inc/trireme-kubernetes/metrics"
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// metricsCollector counts the reported flows before forwarding them to the wrapped collector.
+type metricsCollector struct {
+	collector.EventCollector
+}
+
+// NewMetricsCollector returns a collector counting the flows by action in the Prometheus metrics.
+// All the events are forwarded to next.
+func NewMetricsCollector(next collector.EventCollector) collector.EventCollector {
+	return &metricsCollector{EventCollector: next}
+}
+
+// CollectFlowEvent counts the flow and forwards it.
+func (c *metricsCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	metrics.Flows.WithLabelValues(flowAction(record)).Inc()
+	c.EventCollector.CollectFlowEvent(record)
+}
+
+// Check reports the health of the wrapped collector.
+func (c *metricsCollector) Check() error {
+	if checker, ok := c.EventCollector.(health.Checker); ok {
+		return checker.Check()
+	}
+	return nil
+}
+
+// flowAction returns the action of the flow: accept, reject, or would-drop for flows accepted only in audit mode.
+func flowAction(record *collector.FlowRecord) string {
+	switch {
+	case record.Action&policy.Reject != 0:
+		return "reject"
+	case record.PolicyID == resolver.WouldDropPolicyID:
+		return resolver.WouldDropPolicyID
+	default:
+		return "accept"
+	}
+}
+
+// flowPolicyID returns the PolicyID of the NetworkPolicy rule that decided the flow. The flows rejected
+// without matching any rule are reported with the DefaultDenyPolicyID.
+func flowPolicyID(record *collector.FlowRecord) string {
+	if record.PolicyID == "" && record.Action&policy.Reject != 0 {
+		return resolver.DefaultDenyPolicyID
+	}
+	return record.PolicyID
+}
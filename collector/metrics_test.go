@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestFlowAction(t *testing.T) {
+	tests := []struct {
+		record   *collector.FlowRecord
+		expected string
+	}{
+		{record: &collector.FlowRecord{Action: policy.Accept}, expected: "accept"},
+		{record: &collector.FlowRecord{Action: policy.Reject}, expected: "reject"},
+		{record: &collector.FlowRecord{Action: policy.Accept, PolicyID: resolver.WouldDropPolicyID}, expected: "would-drop"},
+	}
+
+	for _, test := range tests {
+		if action := flowAction(test.record); action != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, action)
+		}
+	}
+}
+
+func TestFlowPolicyID(t *testing.T) {
+	tests := []struct {
+		record   *collector.FlowRecord
+		expected string
+	}{
+		{record: &collector.FlowRecord{Action: policy.Accept, PolicyID: "default/web#ingress[0].from[1]"}, expected: "default/web#ingress[0].from[1]"},
+		{record: &collector.FlowRecord{Action: policy.Reject, PolicyID: "default/web#ingress[0].from[1]"}, expected: "default/web#ingress[0].from[1]"},
+		{record: &collector.FlowRecord{Action: policy.Reject}, expected: resolver.DefaultDenyPolicyID},
+		{record: &collector.FlowRecord{Action: policy.Accept}, expected: ""},
+	}
+
+	for _, test := range tests {
+		if policyID := flowPolicyID(test.record); policyID != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, policyID)
+		}
+	}
+}
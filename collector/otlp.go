@@ -0,0 +1,407 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+const (
+	// OTLPProtocolGRPC exports the records with OTLP/gRPC.
+	OTLPProtocolGRPC = "grpc"
+	// OTLPProtocolHTTPJSON exports the records with OTLP/HTTP and the JSON encoding.
+	OTLPProtocolHTTPJSON = "http/json"
+)
+
+const (
+	// otlpMaxRetries is the number of times an export is retried before its batch is dropped.
+	otlpMaxRetries = 5
+	// otlpInitialBackoff is the delay before the first retry. It is doubled at each retry.
+	otlpInitialBackoff = time.Second
+	// otlpMaxBackoff caps the delay between two retries.
+	otlpMaxBackoff = 30 * time.Second
+	// otlpTimeout is the timeout of an export request.
+	otlpTimeout = 10 * time.Second
+	// otlpScope is the instrumentation scope of the exported log records.
+	otlpScope = "trireme-kubernetes"
+)
+
+// otlpCollector batches the flow and container events into OTLP log records and exports them
+// to an OTLP receiver.
+type otlpCollector struct {
+	collector.DefaultCollector
+
+	endpoint      string
+	exporter      otlpExporter
+	nodeName      string
+	batchSize     int
+	flushInterval time.Duration
+	pus           *puCache
+	now           func() time.Time
+	// backoff is the delay before the first retry of an export.
+	backoff time.Duration
+
+	// records is the bounded buffer of the records waiting to be exported.
+	records  chan *otlpRecord
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	sync.RWMutex
+	err     error
+	dropped int
+}
+
+// otlpExporter sends the requests to an OTLP receiver with one of the OTLP protocols.
+type otlpExporter interface {
+	encode(request *otlpLogsRequest) ([]byte, error)
+	// send sends the encoded request. It returns whether a failure can be retried.
+	send(data []byte) (bool, error)
+}
+
+// otlpHTTPExporter sends the requests to an OTLP/HTTP receiver with the JSON encoding.
+type otlpHTTPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// otlpRecord is a log record together with the pod of the PU that reported it.
+type otlpRecord struct {
+	pu     puMetadata
+	record otlpLogRecord
+}
+
+// The types below are the subset of the OTLP ExportLogsServiceRequest used by the collector.
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScopeInfo   `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScopeInfo struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	SeverityText string         `json:"severityText"`
+	Body         otlpAnyValue   `json:"body"`
+	Attributes   []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpKeyValue {
+	// 64 bits integers are encoded as strings in the JSON encoding of OTLP.
+	v := strconv.FormatInt(value, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &v}}
+}
+
+// NewOTLPCollector returns a collector exporting the flow and container events as OTLP log records with
+// the given protocol: OTLPProtocolGRPC to the OTLP/gRPC endpoint (ex: http://otel-collector:4317), or
+// OTLPProtocolHTTPJSON to the OTLP/HTTP logs endpoint (ex: http://otel-collector:4318/v1/logs).
+// The records are exported by batches of batchSize, or every flushInterval. At most bufferSize records wait
+// to be exported: new records are dropped while the buffer is full. The node, namespace and pod of the
+// reporting PU are set as resource attributes.
+func NewOTLPCollector(endpoint, protocol, nodeName string, batchSize, bufferSize int, flushInterval time.Duration) (collector.EventCollector, error) {
+	zap.L().Info("Using OTLP collector", zap.String("endpoint", endpoint), zap.String("protocol", protocol), zap.Int("batchSize", batchSize), zap.Int("bufferSize", bufferSize))
+	if endpoint == "" {
+		return nil, fmt.Errorf("No OTLP endpoint given")
+	}
+	if batchSize < 1 || bufferSize < 1 || flushInterval <= 0 {
+		return nil, fmt.Errorf("Invalid OTLP batching: batch size %d, buffer size %d, flush interval %s", batchSize, bufferSize, flushInterval)
+	}
+
+	var exporter otlpExporter
+	switch protocol {
+	case OTLPProtocolGRPC:
+		grpcExporter, err := newOTLPGRPCExporter(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		exporter = grpcExporter
+	case OTLPProtocolHTTPJSON:
+		exporter = &otlpHTTPExporter{endpoint: endpoint, client: &http.Client{Timeout: otlpTimeout}}
+	default:
+		return nil, fmt.Errorf("Unknown OTLP protocol %s", protocol)
+	}
+
+	c := &otlpCollector{
+		endpoint:      endpoint,
+		exporter:      exporter,
+		nodeName:      nodeName,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		pus:           newPUCache(),
+		now:           time.Now,
+		backoff:       otlpInitialBackoff,
+		records:       make(chan *otlpRecord, bufferSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// CollectFlowEvent queues the flow.
+func (c *otlpCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	src, dst := flowEndpoints(c.pus, record)
+	attributes := []otlpKeyValue{
+		otlpString("event.type", "flow"),
+		otlpString("source.namespace", src.namespace),
+		otlpString("source.pod", src.pod),
+		otlpString("destination.namespace", dst.namespace),
+		otlpString("destination.pod", dst.pod),
+		otlpString("trireme.action", flowAction(record)),
+		otlpString("trireme.policy_id", flowPolicyID(record)),
+	}
+	attributes = append(attributes, otlpEndpointAttributes("source", src)...)
+	attributes = append(attributes, otlpEndpointAttributes("destination", dst)...)
+	if record.Source != nil {
+		attributes = append(attributes, otlpString("source.ip", record.Source.IP), otlpInt("source.port", int64(record.Source.Port)))
+	}
+	if record.Destination != nil {
+		attributes = append(attributes, otlpString("destination.ip", record.Destination.IP), otlpInt("destination.port", int64(record.Destination.Port)))
+	}
+
+	c.enqueue(c.pus.endpoint(&collector.EndPoint{ID: record.ContextID}), "flow", attributes)
+}
+
+// CollectContainerEvent queues the container event and keeps track of the pod of the PU.
+func (c *otlpCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.enqueue(c.pus.container(record), "container", []otlpKeyValue{
+		otlpString("event.type", "container"),
+		otlpString("trireme.context_id", record.ContextID),
+		otlpString("trireme.event", record.Event),
+	})
+}
+
+// otlpEndpointAttributes returns the attributes of the pod of a flow endpoint known from the enrichment.
+func otlpEndpointAttributes(endpoint string, pu puMetadata) []otlpKeyValue {
+	attributes := []otlpKeyValue{}
+	if pu.workload != "" {
+		attributes = append(attributes, otlpString(endpoint+".workload", pu.workload))
+	}
+	if pu.services != "" {
+		attributes = append(attributes, otlpString(endpoint+".services", pu.services))
+	}
+	if pu.node != "" {
+		attributes = append(attributes, otlpString(endpoint+".node", pu.node))
+	}
+	return attributes
+}
+
+// Check returns the error of the last export, if it failed.
+func (c *otlpCollector) Check() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.err
+}
+
+// Close exports the buffered records and stops the collector.
+func (c *otlpCollector) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+	return nil
+}
+
+func (c *otlpCollector) enqueue(pu puMetadata, body string, attributes []otlpKeyValue) {
+	record := &otlpRecord{
+		pu: pu,
+		record: otlpLogRecord{
+			TimeUnixNano: strconv.FormatInt(c.now().UnixNano(), 10),
+			SeverityText: "INFO",
+			Body:         otlpAnyValue{StringValue: &body},
+			Attributes:   attributes,
+		},
+	}
+
+	select {
+	case c.records <- record:
+	default:
+		c.Lock()
+		defer c.Unlock()
+		// Only the first of consecutive drops is logged.
+		if c.dropped == 0 {
+			zap.L().Warn("OTLP collector buffer full, dropping records", zap.String("endpoint", c.endpoint))
+		}
+		c.dropped++
+	}
+}
+
+// run exports the records by batches until the collector is stopped.
+func (c *otlpCollector) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*otlpRecord, 0, c.batchSize)
+	for {
+		select {
+		case record := <-c.records:
+			batch = append(batch, record)
+			if len(batch) < c.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-c.stop:
+			// Draining the buffer. The exports are not retried anymore.
+			for {
+				select {
+				case record := <-c.records:
+					batch = append(batch, record)
+					if len(batch) == c.batchSize {
+						c.export(batch)
+						batch = batch[:0]
+					}
+				default:
+					c.export(batch)
+					return
+				}
+			}
+		}
+
+		c.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// export sends the batch, retrying with an exponential backoff until it is sent, the error is not
+// retryable, otlpMaxRetries is reached or the collector is stopped.
+func (c *otlpCollector) export(batch []*otlpRecord) {
+	if len(batch) == 0 {
+		return
+	}
+
+	data, err := c.exporter.encode(c.request(batch))
+	if err != nil {
+		c.setError(fmt.Errorf("Couldn't encode OTLP request: %s", err), len(batch))
+		return
+	}
+
+	backoff := c.backoff
+	for retry := 0; ; retry++ {
+		retryable, err := c.exporter.send(data)
+		if err == nil {
+			c.setError(nil, 0)
+			return
+		}
+		if !retryable || retry == otlpMaxRetries {
+			c.setError(err, len(batch))
+			return
+		}
+		zap.L().Debug("Retrying OTLP export", zap.String("endpoint", c.endpoint), zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-c.stop:
+			c.setError(err, len(batch))
+			return
+		}
+		backoff *= 2
+		if backoff > otlpMaxBackoff {
+			backoff = otlpMaxBackoff
+		}
+	}
+}
+
+func (e *otlpHTTPExporter) encode(request *otlpLogsRequest) ([]byte, error) {
+	return json.Marshal(request)
+}
+
+// send posts the request.
+func (e *otlpHTTPExporter) send(data []byte) (bool, error) {
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return true, fmt.Errorf("OTLP export failed: %s", err)
+	}
+	resp.Body.Close()
+	return otlpHTTPStatus(resp)
+}
+
+// otlpHTTPStatus returns the error of a non 2xx response, and whether it can be retried.
+func otlpHTTPStatus(resp *http.Response) (bool, error) {
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return true, fmt.Errorf("OTLP export failed: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("OTLP export rejected: %s", resp.Status)
+	}
+}
+
+// request groups the records of the batch by pod, the resource of the records.
+func (c *otlpCollector) request(batch []*otlpRecord) *otlpLogsRequest {
+	request := &otlpLogsRequest{}
+	resources := map[puMetadata]int{}
+	for _, record := range batch {
+		i, ok := resources[record.pu]
+		if !ok {
+			i = len(request.ResourceLogs)
+			resources[record.pu] = i
+			request.ResourceLogs = append(request.ResourceLogs, otlpResourceLogs{
+				Resource: c.resource(record.pu),
+				ScopeLogs: []otlpScopeLogs{
+					{Scope: otlpScopeInfo{Name: otlpScope}},
+				},
+			})
+		}
+		scopeLogs := &request.ResourceLogs[i].ScopeLogs[0]
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, record.record)
+	}
+	return request
+}
+
+func (c *otlpCollector) resource(pu puMetadata) otlpResource {
+	attributes := []otlpKeyValue{otlpString("k8s.node.name", c.nodeName)}
+	if pu.namespace != externalEndpoint {
+		attributes = append(attributes, otlpString("k8s.namespace.name", pu.namespace), otlpString("k8s.pod.name", pu.pod))
+	}
+	return otlpResource{Attributes: attributes}
+}
+
+func (c *otlpCollector) setError(err error, dropped int) {
+	c.Lock()
+	defer c.Unlock()
+	if err != nil {
+		zap.L().Error("Unable to export OTLP records", zap.String("endpoint", c.endpoint), zap.Int("dropped", dropped), zap.Error(err))
+	} else if c.dropped > 0 {
+		zap.L().Warn("OTLP collector buffer dropped records", zap.String("endpoint", c.endpoint), zap.Int("dropped", c.dropped))
+		c.dropped = 0
+	}
+	c.err = err
+}
@@ -0,0 +1,130 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// otlpReceiver is a stand-in OTLP/HTTP receiver answering with the given status codes in turn,
+// and 200 once they are exhausted.
+func otlpReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan *otlpLogsRequest) {
+	requests := make(chan *otlpLogsRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &otlpLogsRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		requests <- request
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	return server, requests
+}
+
+func receive(t *testing.T, requests chan *otlpLogsRequest) *otlpLogsRequest {
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No OTLP request received")
+	}
+	return nil
+}
+
+func attribute(attributes []otlpKeyValue, key string) string {
+	for _, attribute := range attributes {
+		if attribute.Key != key {
+			continue
+		}
+		if attribute.Value.StringValue != nil {
+			return *attribute.Value.StringValue
+		}
+		if attribute.Value.IntValue != nil {
+			return *attribute.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func TestOTLPCollectorBatch(t *testing.T) {
+	server, requests := otlpReceiver(t)
+	defer server.Close()
+
+	c, err := NewOTLPCollector(server.URL, OTLPProtocolHTTPJSON, "node-1", 2, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer c.(*otlpCollector).Close()
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue(resolver.UpstreamNamespaceIdentifier, "default")
+	tags.AppendKeyValue(resolver.UpstreamNameIdentifier, "web-0")
+	c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Tags: tags, Event: collector.ContainerStart})
+	c.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:   "abc",
+		Source:      &collector.EndPoint{ID: "10.0.0.1", IP: "10.0.0.1", Port: 34567},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80},
+		Action:      policy.Accept,
+	})
+
+	request := receive(t, requests)
+	if len(request.ResourceLogs) != 1 {
+		t.Fatalf("Expected the records of a single pod, got %d", len(request.ResourceLogs))
+	}
+	resource := request.ResourceLogs[0].Resource.Attributes
+	if attribute(resource, "k8s.node.name") != "node-1" || attribute(resource, "k8s.namespace.name") != "default" || attribute(resource, "k8s.pod.name") != "web-0" {
+		t.Errorf("Unexpected resource attributes %v", resource)
+	}
+	records := request.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if attribute(records[1].Attributes, "destination.pod") != "web-0" || attribute(records[1].Attributes, "destination.port") != "80" {
+		t.Errorf("Unexpected flow attributes %v", records[1].Attributes)
+	}
+}
+
+func TestOTLPCollectorRetry(t *testing.T) {
+	server, requests := otlpReceiver(t, http.StatusServiceUnavailable, http.StatusBadRequest)
+	defer server.Close()
+
+	c, err := NewOTLPCollector(server.URL, OTLPProtocolHTTPJSON, "node-1", 1, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	otlp := c.(*otlpCollector)
+	otlp.backoff = time.Millisecond
+
+	// The unavailable receiver is retried, and the rejected batch is dropped.
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	receive(t, requests)
+	receive(t, requests)
+
+	// Close waits for the export of the buffered records.
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	otlp.Close()
+	receive(t, requests)
+	if err := otlp.Check(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestOTLPCollectorBuffer(t *testing.T) {
+	c := &otlpCollector{pus: newPUCache(), now: time.Now, records: make(chan *otlpRecord, 1)}
+
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	if len(c.records) != 1 || c.dropped != 1 {
+		t.Errorf("Expected 1 buffered and 1 dropped record, got %d and %d", len(c.records), c.dropped)
+	}
+}
@@ -0,0 +1,235 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/http2"
+)
+
+// otlpGRPCLogsPath is the path of the Export method of the OTLP logs service.
+const otlpGRPCLogsPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// otlpGRPCRetryable are the gRPC status codes of the failed exports that can be retried:
+// CANCELLED, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, ABORTED, OUT_OF_RANGE, UNAVAILABLE and DATA_LOSS.
+var otlpGRPCRetryable = map[int]bool{1: true, 4: true, 8: true, 10: true, 11: true, 14: true, 15: true}
+
+// otlpGRPCExporter sends the requests to an OTLP/gRPC receiver. The unary gRPC calls are made directly
+// over HTTP/2, with the protobuf encoding of the requests, to not depend on the gRPC and OpenTelemetry packages.
+type otlpGRPCExporter struct {
+	url    string
+	client *http.Client
+}
+
+// newOTLPGRPCExporter returns an exporter to the OTLP/gRPC receiver at endpoint: http://host:port
+// for gRPC without TLS, or https://host:port.
+func newOTLPGRPCExporter(endpoint string) (*otlpGRPCExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid OTLP endpoint %s: %s", endpoint, err)
+	}
+	if u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("Invalid OTLP/gRPC endpoint %s: should be http://host:port or https://host:port", endpoint)
+	}
+
+	transport := &http2.Transport{}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		// Without TLS, gRPC uses HTTP/2 with prior knowledge.
+		transport.AllowHTTP = true
+		transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, otlpTimeout)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid OTLP/gRPC endpoint %s: should be http://host:port or https://host:port", endpoint)
+	}
+
+	return &otlpGRPCExporter{
+		url:    u.Scheme + "://" + u.Host + otlpGRPCLogsPath,
+		client: &http.Client{Transport: transport, Timeout: otlpTimeout},
+	}, nil
+}
+
+// encode returns the request encoded with protobuf, in a gRPC message frame.
+func (e *otlpGRPCExporter) encode(request *otlpLogsRequest) ([]byte, error) {
+	message, err := protoLogsRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	// The frame starts with the uncompressed flag and the length of the message.
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...), nil
+}
+
+// send calls the Export method of the logs service.
+func (e *otlpGRPCExporter) send(data []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("OTLP export failed: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("OTLP export failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// The trailers are only received once the body is read.
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return true, fmt.Errorf("OTLP export failed: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return otlpHTTPStatus(resp)
+	}
+
+	// The status is in the headers of the responses without body.
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return false, fmt.Errorf("OTLP export failed: invalid gRPC status %q", status)
+	}
+	if code == 0 {
+		return false, nil
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	if otlpGRPCRetryable[code] {
+		return true, fmt.Errorf("OTLP export failed: gRPC status %d: %s", code, message)
+	}
+	return false, fmt.Errorf("OTLP export rejected: gRPC status %d: %s", code, message)
+}
+
+// The functions below encode the OTLP ExportLogsServiceRequest with protobuf.
+// They append the fields of the messages to b, with the field numbers of the opentelemetry-proto 1.0
+// messages, which are stable and can't be renumbered:
+//   ExportLogsServiceRequest: resource_logs = 1
+//   ResourceLogs: resource = 1, scope_logs = 2
+//   ScopeLogs: scope = 1, log_records = 2
+//   LogRecord: time_unix_nano = 1, severity_text = 3, body = 5, attributes = 6
+//   Resource: attributes = 1
+//   InstrumentationScope: name = 1
+//   KeyValue: key = 1, value = 2
+//   AnyValue: string_value = 1, int_value = 3
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+func protoLogsRequest(request *otlpLogsRequest) ([]byte, error) {
+	b := []byte{}
+	for _, resourceLogs := range request.ResourceLogs {
+		message, err := protoResourceLogs(resourceLogs)
+		if err != nil {
+			return nil, err
+		}
+		b = protoBytes(b, 1, message)
+	}
+	return b, nil
+}
+
+func protoResourceLogs(resourceLogs otlpResourceLogs) ([]byte, error) {
+	resource, err := protoKeyValues(nil, 1, resourceLogs.Resource.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	b := protoBytes(nil, 1, resource)
+	for _, scopeLogs := range resourceLogs.ScopeLogs {
+		scope := protoBytes(nil, 1, []byte(scopeLogs.Scope.Name))
+		message := protoBytes(nil, 1, scope)
+		for _, record := range scopeLogs.LogRecords {
+			logRecord, err := protoLogRecord(record)
+			if err != nil {
+				return nil, err
+			}
+			message = protoBytes(message, 2, logRecord)
+		}
+		b = protoBytes(b, 2, message)
+	}
+	return b, nil
+}
+
+func protoLogRecord(record otlpLogRecord) ([]byte, error) {
+	timeUnixNano, err := strconv.ParseUint(record.TimeUnixNano, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid OTLP record time %s: %s", record.TimeUnixNano, err)
+	}
+	b := protoFixed64(nil, 1, timeUnixNano)
+	b = protoBytes(b, 3, []byte(record.SeverityText))
+	body, err := protoAnyValue(record.Body)
+	if err != nil {
+		return nil, err
+	}
+	b = protoBytes(b, 5, body)
+	return protoKeyValues(b, 6, record.Attributes)
+}
+
+func protoKeyValues(b []byte, field int, attributes []otlpKeyValue) ([]byte, error) {
+	for _, attribute := range attributes {
+		value, err := protoAnyValue(attribute.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid OTLP attribute %s: %s", attribute.Key, err)
+		}
+		keyValue := protoBytes(nil, 1, []byte(attribute.Key))
+		keyValue = protoBytes(keyValue, 2, value)
+		b = protoBytes(b, field, keyValue)
+	}
+	return b, nil
+}
+
+func protoAnyValue(value otlpAnyValue) ([]byte, error) {
+	switch {
+	case value.StringValue != nil:
+		return protoBytes(nil, 1, []byte(*value.StringValue)), nil
+	case value.IntValue != nil:
+		i, err := strconv.ParseInt(*value.IntValue, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return protoVarint(protoTag(nil, 3, protoWireVarint), uint64(i)), nil
+	default:
+		return nil, nil
+	}
+}
+
+func protoTag(b []byte, field int, wireType int) []byte {
+	return protoVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func protoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func protoFixed64(b []byte, field int, v uint64) []byte {
+	b = protoTag(b, field, protoWireFixed64)
+	var fixed [8]byte
+	binary.LittleEndian.PutUint64(fixed[:], v)
+	return append(b, fixed[:]...)
+}
+
+func protoBytes(b []byte, field int, data []byte) []byte {
+	b = protoTag(b, field, protoWireBytes)
+	b = protoVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,240 @@
+package collector
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+	"golang.org/x/net/http2"
+)
+
+// otlpGRPCReceiver is a stand-in OTLP/gRPC receiver answering with the given gRPC status codes in turn,
+// and OK once they are exhausted. It returns its endpoint and the protobuf messages it receives.
+func otlpGRPCReceiver(t *testing.T, codes ...int) (string, chan []byte, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	messages := make(chan []byte, 10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpGRPCLogsPath || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("Unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		frame, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(frame) < 5 || int(binary.BigEndian.Uint32(frame[1:5])) != len(frame)-5 {
+			t.Errorf("Invalid gRPC frame %v: %v", frame, err)
+			return
+		}
+		messages <- frame[5:]
+
+		code := 0
+		if len(codes) > 0 {
+			code = codes[0]
+			codes = codes[1:]
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		// Empty ExportLogsServiceResponse.
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", strconv.Itoa(code))
+		w.Header().Set("Grpc-Message", "test%20status")
+	})
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), messages, func() {
+		listener.Close()
+		for {
+			select {
+			case conn := <-conns:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	}
+}
+
+func receiveMessage(t *testing.T, messages chan []byte) []byte {
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No OTLP request received")
+	}
+	return nil
+}
+
+// protoField is a decoded protobuf field: the value of a varint or fixed64 field, or the data of a bytes field.
+type protoField struct {
+	value uint64
+	data  []byte
+}
+
+// protoFields decodes the fields of a protobuf message by field number.
+func protoFields(t *testing.T, b []byte) map[int][]protoField {
+	fields := map[int][]protoField{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("Invalid protobuf tag")
+		}
+		b = b[n:]
+		field := protoField{}
+		switch tag & 7 {
+		case protoWireVarint:
+			field.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("Invalid protobuf varint")
+			}
+			b = b[n:]
+		case protoWireFixed64:
+			if len(b) < 8 {
+				t.Fatalf("Invalid protobuf fixed64")
+			}
+			field.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoWireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				t.Fatalf("Invalid protobuf bytes")
+			}
+			field.data = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			t.Fatalf("Unexpected protobuf wire type %d", tag&7)
+		}
+		fields[int(tag>>3)] = append(fields[int(tag>>3)], field)
+	}
+	return fields
+}
+
+// protoAttributes decodes the KeyValue fields into a map of the string and int values.
+func protoAttributes(t *testing.T, keyValues []protoField) map[string]string {
+	attributes := map[string]string{}
+	for _, keyValue := range keyValues {
+		fields := protoFields(t, keyValue.data)
+		value := protoFields(t, fields[2][0].data)
+		if s, ok := value[1]; ok {
+			attributes[string(fields[1][0].data)] = string(s[0].data)
+		} else if i, ok := value[3]; ok {
+			attributes[string(fields[1][0].data)] = strconv.FormatInt(int64(i[0].value), 10)
+		}
+	}
+	return attributes
+}
+
+func TestOTLPCollectorGRPC(t *testing.T) {
+	endpoint, messages, closeReceiver := otlpGRPCReceiver(t)
+	defer closeReceiver()
+
+	c, err := NewOTLPCollector(endpoint, OTLPProtocolGRPC, "node-1", 2, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	otlp := c.(*otlpCollector)
+	defer otlp.Close()
+	now := time.Unix(1500000000, 0)
+	otlp.now = func() time.Time { return now }
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue(resolver.UpstreamNamespaceIdentifier, "default")
+	tags.AppendKeyValue(resolver.UpstreamNameIdentifier, "web-0")
+	c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Tags: tags, Event: collector.ContainerStart})
+	c.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:   "abc",
+		Source:      &collector.EndPoint{ID: "10.0.0.1", IP: "10.0.0.1", Port: 34567},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80},
+		Action:      policy.Accept,
+	})
+
+	request := protoFields(t, receiveMessage(t, messages))
+	if len(request[1]) != 1 {
+		t.Fatalf("Expected the records of a single pod, got %d", len(request[1]))
+	}
+	resourceLogs := protoFields(t, request[1][0].data)
+	resource := protoAttributes(t, protoFields(t, resourceLogs[1][0].data)[1])
+	if resource["k8s.node.name"] != "node-1" || resource["k8s.namespace.name"] != "default" || resource["k8s.pod.name"] != "web-0" {
+		t.Errorf("Unexpected resource attributes %v", resource)
+	}
+
+	scopeLogs := protoFields(t, resourceLogs[2][0].data)
+	if scope := protoFields(t, scopeLogs[1][0].data); string(scope[1][0].data) != otlpScope {
+		t.Errorf("Unexpected scope %s", scope[1][0].data)
+	}
+	if len(scopeLogs[2]) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(scopeLogs[2]))
+	}
+	record := protoFields(t, scopeLogs[2][1].data)
+	if record[1][0].value != uint64(now.UnixNano()) || string(record[3][0].data) != "INFO" {
+		t.Errorf("Unexpected record time %d and severity %s", record[1][0].value, record[3][0].data)
+	}
+	if body := protoFields(t, record[5][0].data); string(body[1][0].data) != "flow" {
+		t.Errorf("Unexpected record body %s", body[1][0].data)
+	}
+	attributes := protoAttributes(t, record[6])
+	if attributes["destination.pod"] != "web-0" || attributes["destination.port"] != "80" || attributes["source.port"] != "34567" {
+		t.Errorf("Unexpected flow attributes %v", attributes)
+	}
+}
+
+func TestOTLPCollectorGRPCRetry(t *testing.T) {
+	// UNAVAILABLE is retried, INVALID_ARGUMENT is not.
+	endpoint, messages, closeReceiver := otlpGRPCReceiver(t, 14, 3)
+	defer closeReceiver()
+
+	c, err := NewOTLPCollector(endpoint, OTLPProtocolGRPC, "node-1", 1, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	otlp := c.(*otlpCollector)
+	otlp.backoff = time.Millisecond
+
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	receiveMessage(t, messages)
+	receiveMessage(t, messages)
+
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	otlp.Close()
+	receiveMessage(t, messages)
+	if err := otlp.Check(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestNewOTLPCollectorErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		protocol string
+	}{
+		{name: "unknown protocol", endpoint: "http://otel-collector:4317", protocol: "http/protobuf"},
+		{name: "grpc endpoint with a path", endpoint: "http://otel-collector:4318/v1/logs", protocol: OTLPProtocolGRPC},
+		{name: "grpc endpoint without scheme", endpoint: "otel-collector:4317", protocol: OTLPProtocolGRPC},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewOTLPCollector(tt.endpoint, tt.protocol, "node-1", 1, 1, time.Second); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// overflowLabel replaces the labels of the flows exceeding the series limit of the Prometheus collector.
+const overflowLabel = "overflow"
+
+// prometheusCollector aggregates the flow and container events into Prometheus counters.
+type prometheusCollector struct {
+	collector.DefaultCollector
+
+	flows           *prometheus.CounterVec
+	containerEvents *prometheus.CounterVec
+	pus             *puCache
+
+	// maxSeries is the maximum number of flow series. The flows of new series beyond it are counted in the overflow series.
+	maxSeries int
+	sync.Mutex
+	// series keeps the labels of the flow series by key.
+	series map[string][]string
+}
+
+// NewPrometheusCollector returns a collector counting the flows by source and destination pod, action and policy,
+// and the container events by type. The counters are registered in the default Prometheus registry.
+// At most maxSeries flow series are created, to bound the cardinality caused by ephemeral pods. The series
+// of a pod are removed once its PU is deleted.
+func NewPrometheusCollector(maxSeries int) (collector.EventCollector, error) {
+	zap.L().Info("Using Prometheus collector", zap.Int("maxSeries", maxSeries))
+	if maxSeries < 1 {
+		return nil, fmt.Errorf("Invalid maximum number of series: %d", maxSeries)
+	}
+
+	c := &prometheusCollector{
+		flows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trireme",
+			Subsystem: "collector",
+			Name:      "flows_total",
+			Help:      "Number of flows by source and destination pod, action and policy.",
+		}, []string{"src_namespace", "src_pod", "dst_namespace", "dst_pod", "action", "policy_id"}),
+		containerEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trireme",
+			Subsystem: "collector",
+			Name:      "container_events_total",
+			Help:      "Number of container events by type.",
+		}, []string{"event"}),
+		pus:       newPUCache(),
+		maxSeries: maxSeries,
+		series:    map[string][]string{},
+	}
+
+	if err := prometheus.Register(c.flows); err != nil {
+		return nil, fmt.Errorf("Couldn't register flow counters: %s", err)
+	}
+	if err := prometheus.Register(c.containerEvents); err != nil {
+		return nil, fmt.Errorf("Couldn't register container event counters: %s", err)
+	}
+	return c, nil
+}
+
+// CollectFlowEvent counts the flow.
+func (c *prometheusCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	src, dst := flowEndpoints(c.pus, record)
+	c.flows.WithLabelValues(c.limit(src.namespace, src.pod, dst.namespace, dst.pod, flowAction(record), flowPolicyID(record))...).Inc()
+}
+
+// CollectContainerEvent counts the container event and keeps track of the pod of the PU.
+// The flow series of the pod are removed when its last PU is deleted.
+func (c *prometheusCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	pu := c.pus.container(record)
+	if record.Event == collector.ContainerDelete && pu.namespace != externalEndpoint && !c.pus.hasPod(pu.namespace, pu.pod) {
+		c.prune(pu.namespace, pu.pod)
+	}
+	c.containerEvents.WithLabelValues(record.Event).Inc()
+}
+
+// limit returns the labels of the flow series, or the overflow labels if the series would exceed maxSeries.
+// The action is kept on the overflow series.
+func (c *prometheusCollector) limit(srcNamespace, srcPod, dstNamespace, dstPod, action, policyID string) []string {
+	labels := []string{srcNamespace, srcPod, dstNamespace, dstPod, action, policyID}
+	key := strings.Join(labels, "\x00")
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.series[key]; ok {
+		return labels
+	}
+	if len(c.series) < c.maxSeries {
+		c.series[key] = labels
+		return labels
+	}
+	return []string{overflowLabel, overflowLabel, overflowLabel, overflowLabel, action, overflowLabel}
+}
+
+// prune removes the flow series from or to the pod, making room for the series of the new pods.
+func (c *prometheusCollector) prune(namespace string, pod string) {
+	c.Lock()
+	defer c.Unlock()
+	for key, labels := range c.series {
+		if (labels[0] == namespace && labels[1] == pod) || (labels[2] == namespace && labels[3] == pod) {
+			delete(c.series, key)
+			c.flows.DeleteLabelValues(labels...)
+		}
+	}
+}
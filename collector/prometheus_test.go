@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestPrometheusCollectorLimit(t *testing.T) {
+	c := &prometheusCollector{maxSeries: 2, series: map[string][]string{}}
+
+	first := c.limit("default", "web-0", "payments", "db-0", "accept", "")
+	second := c.limit("default", "web-1", "payments", "db-0", "accept", "")
+	if first[1] != "web-0" || second[1] != "web-1" {
+		t.Errorf("Expected series under the limit to be kept, got %v and %v", first, second)
+	}
+
+	overflow := c.limit("default", "web-2", "payments", "db-0", "reject", "")
+	expected := []string{overflowLabel, overflowLabel, overflowLabel, overflowLabel, "reject", overflowLabel}
+	if !reflect.DeepEqual(overflow, expected) {
+		t.Errorf("Expected overflow series %v, got %v", expected, overflow)
+	}
+
+	// Existing series are still counted once the limit is reached.
+	if again := c.limit("default", "web-0", "payments", "db-0", "accept", ""); !reflect.DeepEqual(again, first) {
+		t.Errorf("Expected existing series %v, got %v", first, again)
+	}
+}
+
+func TestPrometheusCollectorPrune(t *testing.T) {
+	c := &prometheusCollector{
+		flows:           prometheus.NewCounterVec(prometheus.CounterOpts{Name: "flows_total"}, []string{"src_namespace", "src_pod", "dst_namespace", "dst_pod", "action", "policy_id"}),
+		containerEvents: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "container_events_total"}, []string{"event"}),
+		pus:             newPUCache(),
+		maxSeries:       2,
+		series:          map[string][]string{},
+	}
+	start := func(contextID, pod string) {
+		tags := policy.NewTagStore()
+		tags.AppendKeyValue(resolver.UpstreamNamespaceIdentifier, "default")
+		tags.AppendKeyValue(resolver.UpstreamNameIdentifier, pod)
+		c.CollectContainerEvent(&collector.ContainerRecord{ContextID: contextID, Tags: tags, Event: collector.ContainerStart})
+	}
+	flow := func(src, dst string) {
+		c.CollectFlowEvent(&collector.FlowRecord{Source: &collector.EndPoint{ID: src}, Destination: &collector.EndPoint{ID: dst}, Action: policy.Accept})
+	}
+
+	start("abc", "web-0")
+	start("def", "web-1")
+	flow("abc", "10.0.0.1")
+	flow("10.0.0.1", "def")
+	if len(c.series) != 2 {
+		t.Fatalf("Expected 2 series, got %v", c.series)
+	}
+
+	// web-0 was restarted: its series are kept while a PU still runs it.
+	start("ghi", "web-0")
+	c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: collector.ContainerDelete})
+	if len(c.series) != 2 {
+		t.Errorf("Expected the series of a running pod to be kept, got %v", c.series)
+	}
+
+	c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "ghi", Event: collector.ContainerDelete})
+	if len(c.series) != 1 {
+		t.Errorf("Expected the series of the deleted pod to be removed, got %v", c.series)
+	}
+
+	// The series of the new pods are counted again instead of overflowing.
+	start("jkl", "web-2")
+	flow("jkl", "10.0.0.1")
+	if labels := c.limit("default", "web-2", externalEndpoint, externalEndpoint, "accept", ""); labels[1] != "web-2" {
+		t.Errorf("Expected the series of the new pod to be kept, got %v", labels)
+	}
+}
+
+func TestPUCache(t *testing.T) {
+	c := newPUCache()
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue(resolver.UpstreamNamespaceIdentifier, "default")
+	tags.AppendKeyValue(resolver.UpstreamNameIdentifier, "web-0")
+
+	c.update(&collector.ContainerRecord{ContextID: "abc", Tags: tags, Event: collector.ContainerStart})
+	if pu := c.endpoint(&collector.EndPoint{ID: "abc"}); pu.namespace != "default" || pu.pod != "web-0" {
+		t.Errorf("Expected default/web-0, got %v", pu)
+	}
+	if pu := c.endpoint(&collector.EndPoint{ID: "10.0.0.1"}); pu.namespace != externalEndpoint {
+		t.Errorf("Expected external endpoint, got %v", pu)
+	}
+
+	c.update(&collector.ContainerRecord{ContextID: "abc", Event: collector.ContainerDelete})
+	if pu := c.endpoint(&collector.EndPoint{ID: "abc"}); pu.namespace != externalEndpoint {
+		t.Errorf("Expected deleted PU to be forgotten, got %v", pu)
+	}
+}
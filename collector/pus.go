@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	"go.aporeto.io/trireme-lib/collector"
+)
+
+// externalEndpoint names the namespace and pod of the flow endpoints that are not PUs.
+const externalEndpoint = "external"
+
+// puMetadata is the Kubernetes pod of a PU. The workload, services and node are only known for the flows
+// enriched by the enrichment collector.
+type puMetadata struct {
+	namespace string
+	pod       string
+	workload  string
+	// services are the comma separated names of the Services selecting the pod.
+	services string
+	node     string
+}
+
+// puCache keeps the pod of each PU, as reported by the container events.
+type puCache struct {
+	sync.RWMutex
+	pus map[string]puMetadata
+}
+
+func newPUCache() *puCache {
+	return &puCache{
+		pus: map[string]puMetadata{},
+	}
+}
+
+// update records or forgets the pod of the PU of the container event.
+func (c *puCache) update(record *collector.ContainerRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	if record.Event == collector.ContainerDelete {
+		delete(c.pus, record.ContextID)
+		return
+	}
+	if record.Tags == nil {
+		return
+	}
+	namespace, ok := record.Tags.Get(resolver.UpstreamNamespaceIdentifier)
+	if !ok {
+		return
+	}
+	pod, _ := record.Tags.Get(resolver.UpstreamNameIdentifier)
+	c.pus[record.ContextID] = puMetadata{namespace: namespace, pod: pod}
+}
+
+// endpoint returns the pod of the flow endpoint, or externalEndpoint if the endpoint is not a known PU.
+func (c *puCache) endpoint(endpoint *collector.EndPoint) puMetadata {
+	if endpoint == nil {
+		return puMetadata{namespace: externalEndpoint, pod: externalEndpoint}
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	if pu, ok := c.pus[endpoint.ID]; ok {
+		return pu
+	}
+	return puMetadata{namespace: externalEndpoint, pod: externalEndpoint}
+}
+
+// hasPod returns true if a PU of the cache runs the pod.
+func (c *puCache) hasPod(namespace string, pod string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, pu := range c.pus {
+		if pu.namespace == namespace && pu.pod == pod {
+			return true
+		}
+	}
+	return false
+}
+
+// container updates the cache with the container event and returns the pod of its PU.
+// The pod of a deleted PU is returned before it is forgotten.
+func (c *puCache) container(record *collector.ContainerRecord) puMetadata {
+	pu := c.endpoint(&collector.EndPoint{ID: record.ContextID})
+	c.update(record)
+	if pu.namespace == externalEndpoint {
+		pu = c.endpoint(&collector.EndPoint{ID: record.ContextID})
+	}
+	return pu
+}
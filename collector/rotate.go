@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// backupTimeFormat is the timestamp suffix of the rotated files. It sorts lexically in time order.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// rotateRetryInterval is the time after which a rotation that failed to rename the file is retried.
+const rotateRetryInterval = time.Minute
+
+// rotatingFile is a file writer that renames the file and starts a new one once it reaches
+// maxSize bytes or has been open for maxAge. At most maxBackups rotated files are kept.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	now        func() time.Time
+
+	sync.Mutex
+	// file is nil after a failed rotation until it is reopened by the next write.
+	file   *os.File
+	size   int64
+	opened time.Time
+	// rotateAfter delays the next rotation after a failed one.
+	rotateAfter time.Time
+	closed      bool
+}
+
+// newRotatingFile opens the file at path for appending. A zero maxSize or maxAge disables the
+// corresponding rotation and a zero maxBackups keeps all the rotated files.
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write writes p to the file, rotating it first if p doesn't fit or the file is too old.
+// p is never split across two files.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return 0, fmt.Errorf("File %s is closed", r.path)
+	}
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			if r.file == nil {
+				return 0, err
+			}
+			// The current file is still open and p is appended to it.
+			zap.L().Error("Unable to rotate the collector file", zap.String("path", r.path), zap.Error(err))
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the file. Subsequent writes fail.
+func (r *rotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *rotatingFile) shouldRotate(size int64) bool {
+	if r.size == 0 || r.now().Before(r.rotateAfter) {
+		return false
+	}
+	if r.maxSize > 0 && r.size+size > r.maxSize {
+		return true
+	}
+	return r.maxAge > 0 && r.now().Sub(r.opened) >= r.maxAge
+}
+
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("Couldn't create directory of %s: %s", r.path, err)
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldn't open %s: %s", r.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Couldn't stat %s: %s", r.path, err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+	switch {
+	case r.size == 0:
+		r.opened = r.now()
+	case r.opened.IsZero():
+		// The file existed before the collector started. It keeps its age across restarts.
+		r.opened = info.ModTime()
+	}
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix, opens a new one and removes the oldest rotated files.
+// If the file can't be renamed, it is kept open and the rotation is retried after rotateRetryInterval. If the
+// new file can't be opened, it is opened again by the next write.
+func (r *rotatingFile) rotate() error {
+	if err := os.Rename(r.path, r.path+"."+r.now().UTC().Format(backupTimeFormat)); err != nil {
+		r.rotateAfter = r.now().Add(rotateRetryInterval)
+		return fmt.Errorf("Couldn't rotate %s: %s", r.path, err)
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	r.size = 0
+	if err != nil {
+		return fmt.Errorf("Couldn't close %s: %s", r.path, err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.removeBackups()
+}
+
+// backups returns the rotated files, oldest first.
+func (r *rotatingFile) backups() ([]string, error) {
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (r *rotatingFile) removeBackups() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := r.backups()
+	if err != nil {
+		return fmt.Errorf("Couldn't list the rotated files of %s: %s", r.path, err)
+	}
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("Couldn't remove %s: %s", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
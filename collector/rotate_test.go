@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trireme-collector")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flows.log")
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := newRotatingFile(path, 10, time.Hour, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	r.now = func() time.Time { return now }
+	defer r.Close()
+
+	write := func(data string) {
+		if _, err := r.Write([]byte(data)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	rotated := func() int {
+		backups, err := r.backups()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		return len(backups)
+	}
+
+	write("12345")
+	write("12345")
+	if n := rotated(); n != 0 {
+		t.Errorf("Expected no rotated file, got %d", n)
+	}
+
+	// The size limit is exceeded.
+	now = now.Add(time.Second)
+	write("1")
+	if n := rotated(); n != 1 {
+		t.Errorf("Expected 1 rotated file, got %d", n)
+	}
+
+	// The file is too old.
+	now = now.Add(time.Hour)
+	write("1")
+	if n := rotated(); n != 2 {
+		t.Errorf("Expected 2 rotated files, got %d", n)
+	}
+
+	// The oldest rotated file is removed.
+	now = now.Add(time.Hour)
+	write("1")
+	if n := rotated(); n != 2 {
+		t.Errorf("Expected 2 rotated files, got %d", n)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(data) != "1" {
+		t.Errorf("Expected the current file to contain the last write, got %q", data)
+	}
+}
+
+func TestRotatingFileExistingFileAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trireme-collector")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The file was last written two hours before the collector restarted.
+	path := filepath.Join(dir, "flows.log")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	modTime := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	r, err := newRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer r.Close()
+
+	if _, err := r.Write([]byte("new")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if backups, _ := r.backups(); len(backups) != 1 {
+		t.Errorf("Expected the old file to be rotated, got %v", backups)
+	}
+}
+
+func TestRotatingFileRecovers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trireme-collector")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flows.log")
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := newRotatingFile(path, 5, 0, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	r.now = func() time.Time { return now }
+	defer r.Close()
+
+	if _, err := r.Write([]byte("12345")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The rotated file can't be created, as a directory has its name.
+	backup := path + "." + now.UTC().Format(backupTimeFormat)
+	if err := os.MkdirAll(filepath.Join(backup, "busy"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// The writes are appended to the current file, and the rotation is not retried before rotateRetryInterval.
+	if _, err := r.Write([]byte("1")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := os.RemoveAll(backup); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := r.Write([]byte("1")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("Expected the rotation not to be retried yet, got %v", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "1234511" {
+		t.Errorf("Expected the current file to contain all the writes, got %q (%v)", data, err)
+	}
+
+	// The rotation is retried once rotateRetryInterval is elapsed.
+	now = now.Add(rotateRetryInterval)
+	backup = path + "." + now.UTC().Format(backupTimeFormat)
+	if _, err := r.Write([]byte("1")); err != nil {
+		t.Fatalf("Expected the file to be rotated, got %s", err)
+	}
+	if data, err := ioutil.ReadFile(backup); err != nil || string(data) != "1234511" {
+		t.Errorf("Expected the rotated file to contain the previous writes, got %q (%v)", data, err)
+	}
+
+	// A file that couldn't be opened after a rotation is opened by the next write.
+	r.file.Close()
+	r.file = nil
+	if _, err := r.Write([]byte("2")); err != nil {
+		t.Fatalf("Expected the file to be reopened, got %s", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "12" {
+		t.Errorf("Expected the current file to contain the last writes, got %q (%v)", data, err)
+	}
+
+	r.Close()
+	if _, err := r.Write([]byte("3")); err == nil {
+		t.Errorf("Expected writes to fail once the file is closed")
+	}
+}
@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// webhookTimeout is the timeout of a webhook request.
+const webhookTimeout = 5 * time.Second
+
+// webhookCollector posts every flow and container event to a webhook.
+type webhookCollector struct {
+	collector.DefaultCollector
+
+	url    string
+	client *http.Client
+	pus    *puCache
+	now    func() time.Time
+
+	sync.RWMutex
+	err error
+}
+
+// NewWebhookCollector returns a collector posting every flow and container event to url, as the JSON object
+// written by the file collector. The requests are sent synchronously: the collector is meant to be a sink of
+// a fan-out collector, which queues the events.
+func NewWebhookCollector(url string) (collector.EventCollector, error) {
+	zap.L().Info("Using webhook collector", zap.String("url", url))
+	if url == "" {
+		return nil, fmt.Errorf("No webhook URL given")
+	}
+
+	return &webhookCollector{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		pus:    newPUCache(),
+		now:    time.Now,
+	}, nil
+}
+
+// CollectFlowEvent posts the flow.
+func (c *webhookCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.post(newFlowLog(c.pus, record, c.now()))
+}
+
+// CollectContainerEvent posts the container event and keeps track of the pod of the PU.
+func (c *webhookCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.post(newContainerLog(c.pus, record, c.now()))
+}
+
+// Check returns the error of the last request, if it failed.
+func (c *webhookCollector) Check() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.err
+}
+
+func (c *webhookCollector) post(event interface{}) {
+	err := c.send(event)
+
+	c.Lock()
+	defer c.Unlock()
+	if err == nil {
+		c.err = nil
+		return
+	}
+	// Only the first of consecutive failures is logged.
+	if c.err == nil {
+		zap.L().Error("Unable to post event to the webhook", zap.String("url", c.url), zap.Error(err))
+	}
+	c.err = fmt.Errorf("Webhook unavailable: %s", err)
+}
+
+func (c *webhookCollector) send(event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	// The body is drained so that the connection is reused by the next events.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestWebhookCollector(t *testing.T) {
+	status := http.StatusOK
+	var received *flowLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = &flowLog{}
+		if err := json.NewDecoder(r.Body).Decode(received); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c, err := NewWebhookCollector(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Reject, PolicyID: "default"})
+	if received == nil || received.Action != "reject" || received.PolicyID != "default" {
+		t.Errorf("Unexpected event %v", received)
+	}
+	if err := c.(*webhookCollector).Check(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	status = http.StatusInternalServerError
+	c.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept})
+	if err := c.(*webhookCollector).Check(); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 	"go.aporeto.io/trireme-lib/controller"
@@ -35,9 +36,60 @@ type Configuration struct {
 
 	KubeconfigPath string
 
+	// InformerResyncPeriod is the interval at which the Kubernetes informers replay their cache.
+	// 0 disables the resync.
+	InformerResyncPeriod time.Duration
+
+	// SyncTimeout is the time given to the initial sync with Kubernetes on startup.
+	SyncTimeout time.Duration
+
+	// PolicyWorkers is the number of pod policies updated concurrently.
+	PolicyWorkers int
+
+	// EnforcementMode is enforce or audit. In audit mode, the flows rejected by the NetworkPolicies
+	// are accepted and reported as would-drop. Namespaces can override it through an annotation.
+	EnforcementMode string
+
+	// NamespaceSelector is the label selector of the namespaces in which the NetworkPolicies are enforced.
+	// ExcludedNamespaces are never enforced, whatever their labels.
+	NamespaceSelector        string
+	ExcludedNamespaces       string
+	ParsedExcludedNamespaces []string
+
 	LogFormat string
 	LogLevel  string
 
+	// HealthAddress is the address serving /healthz, /readyz, /version and /metrics. Empty disables it.
+	HealthAddress string
+
+	// CollectorType selects the collectors receiving the events, among influxdb, prometheus, file, otlp and
+	// webhook (whitespace separated). default reports to no collector.
+	// If empty, influxdb is used when a CollectorEndpoint is given.
+	CollectorType       string
+	ParsedCollectorType []string
+
+	// CollectorQueueSize is the number of events queued for each collector before they are dropped.
+	CollectorQueueSize int
+
+	// CollectorMaxSeries is the maximum number of flow series of the prometheus collector.
+	CollectorMaxSeries int
+
+	// Rotating JSON-lines file of the file collector.
+	CollectorFilePath       string
+	CollectorFileMaxSize    int
+	CollectorFileMaxAge     time.Duration
+	CollectorFileMaxBackups int
+
+	// OTLP endpoint, protocol (grpc or http/json) and batching of the otlp collector.
+	CollectorOTLPEndpoint      string
+	CollectorOTLPProtocol      string
+	CollectorOTLPBatchSize     int
+	CollectorOTLPBufferSize    int
+	CollectorOTLPFlushInterval time.Duration
+
+	// CollectorWebhookURL is the URL to which the webhook collector posts the events.
+	CollectorWebhookURL string
+
 	// Credentials info for InfluxDB Collector interface
 	CollectorEndpoint           string
 	CollectorUser               string
@@ -45,6 +97,11 @@ type Configuration struct {
 	CollectorDB                 string
 	CollectorInsecureSkipVerify bool
 
+	// CollectorFailFast makes the startup fail if InfluxDB can't be reached, instead of reconnecting in the background.
+	CollectorFailFast bool
+	// CollectorSpoolSize is the number of events spooled while InfluxDB is unreachable.
+	CollectorSpoolSize int
+
 	// Enforce defines if this process is an enforcer process (spawned into POD namespaces)
 	Enforce bool `mapstructure:"Enforce"`
 }
@@ -67,13 +124,35 @@ func LoadConfig() (*Configuration, error) {
 	flag.Bool("RemoteEnforcer", true, "Use the Trireme Remote Enforcer.")
 	flag.String("TriremeNetworks", "", "TriremeNetworks")
 	flag.String("KubeconfigPath", "", "KubeConfig used to connect to Kubernetes")
+	flag.Duration("InformerResyncPeriod", 0, "Resync period of the Kubernetes informers. 0 disables the resync")
+	flag.Duration("SyncTimeout", 0, "Timeout of the initial sync with Kubernetes. Default to 2m")
+	flag.Int("PolicyWorkers", 2, "Number of pod policies updated concurrently")
+	flag.String("EnforcementMode", "", "Enforcement mode: enforce/audit. Default to enforce")
+	flag.String("NamespaceSelector", "", "Label selector of the namespaces in which NetworkPolicies are enforced. Default to all")
+	flag.String("ExcludedNamespaces", "", "Namespaces in which NetworkPolicies are never enforced. Default to kube-system")
 	flag.String("LogLevel", "", "Log level. Default to info (trace//debug//info//warn//error//fatal)")
 	flag.String("LogFormat", "", "Log Format. Default to human")
+	flag.String("HealthAddress", "", "Listen address of the health and metrics endpoints (ex: 10.0.0.1:9465). Disabled by default")
+	flag.String("CollectorType", "", "Collector types, whitespace separated: default/influxdb/prometheus/file/otlp/webhook. Default to influxdb if a CollectorEndpoint is given")
+	flag.Int("CollectorQueueSize", 0, "Number of events queued for each collector before they are dropped. Default to 10000")
+	flag.Int("CollectorMaxSeries", 0, "Maximum number of flow series of the prometheus collector. Default to 10000")
+	flag.String("CollectorFilePath", "", "Path of the JSON-lines file of the file collector. Default to /var/log/trireme/flows.log")
+	flag.Int("CollectorFileMaxSize", 0, "Size in megabytes at which the file of the file collector is rotated. Default to 100")
+	flag.Duration("CollectorFileMaxAge", 0, "Age at which the file of the file collector is rotated. Default to 24h")
+	flag.Int("CollectorFileMaxBackups", 0, "Number of rotated files kept by the file collector. Default to 5")
+	flag.String("CollectorOTLPEndpoint", "", "OTLP endpoint of the otlp collector (ex: http://otel-collector:4317 with grpc, http://otel-collector:4318/v1/logs with http/json)")
+	flag.String("CollectorOTLPProtocol", "", "OTLP protocol of the otlp collector: grpc or http/json. Default to grpc")
+	flag.Int("CollectorOTLPBatchSize", 0, "Number of records exported at once by the otlp collector. Default to 512")
+	flag.Int("CollectorOTLPBufferSize", 0, "Number of records buffered by the otlp collector before dropping. Default to 10000")
+	flag.Duration("CollectorOTLPFlushInterval", 0, "Maximum delay before the otlp collector exports the buffered records. Default to 5s")
+	flag.String("CollectorWebhookURL", "", "URL to which the webhook collector posts the events")
 	flag.String("CollectorEndpoint", "", "Endpoint for InfluxDB customer collector")
 	flag.String("CollectorUser", "", "User info for InfluxDB")
 	flag.String("CollectorPass", "", "Pass for InfluxDB")
 	flag.String("CollectorDB", "", "DB for InfluxDB")
 	flag.Bool("CollectorInsecureSkipVerify", false, "InsecureSkipVerify for InfluxDB")
+	flag.Bool("CollectorFailFast", false, "Fail the startup if InfluxDB can't be reached instead of reconnecting in the background")
+	flag.Int("CollectorSpoolSize", 0, "Number of events spooled while InfluxDB is unreachable. Default to 10000")
 	flag.Bool("Enforce", false, "Run Trireme-Kubernetes in Enforce mode.")
 
 	// Setting up default configuration
@@ -84,13 +163,35 @@ func LoadConfig() (*Configuration, error) {
 	viper.SetDefault("RemoteEnforcer", true)
 	viper.SetDefault("TriremeNetworks", "")
 	viper.SetDefault("KubeconfigPath", "")
+	viper.SetDefault("InformerResyncPeriod", 0)
+	viper.SetDefault("SyncTimeout", 2*time.Minute)
+	viper.SetDefault("PolicyWorkers", 2)
+	viper.SetDefault("EnforcementMode", "enforce")
+	viper.SetDefault("NamespaceSelector", "")
+	viper.SetDefault("ExcludedNamespaces", "kube-system")
 	viper.SetDefault("LogLevel", "info")
 	viper.SetDefault("LogFormat", "human")
+	viper.SetDefault("HealthAddress", "")
+	viper.SetDefault("CollectorType", "")
+	viper.SetDefault("CollectorQueueSize", 10000)
+	viper.SetDefault("CollectorMaxSeries", 10000)
+	viper.SetDefault("CollectorFilePath", "/var/log/trireme/flows.log")
+	viper.SetDefault("CollectorFileMaxSize", 100)
+	viper.SetDefault("CollectorFileMaxAge", 24*time.Hour)
+	viper.SetDefault("CollectorFileMaxBackups", 5)
+	viper.SetDefault("CollectorOTLPEndpoint", "")
+	viper.SetDefault("CollectorOTLPProtocol", "grpc")
+	viper.SetDefault("CollectorOTLPBatchSize", 512)
+	viper.SetDefault("CollectorOTLPBufferSize", 10000)
+	viper.SetDefault("CollectorOTLPFlushInterval", 5*time.Second)
+	viper.SetDefault("CollectorWebhookURL", "")
 	viper.SetDefault("CollectorEndpoint", "")
 	viper.SetDefault("CollectorUser", "")
 	viper.SetDefault("CollectorPass", "")
 	viper.SetDefault("CollectorDB", "")
 	viper.SetDefault("CollectorInsecureSkipVerify", "")
+	viper.SetDefault("CollectorFailFast", false)
+	viper.SetDefault("CollectorSpoolSize", 10000)
 	viper.SetDefault("Enforce", false)
 
 	// Binding ENV variables
@@ -157,6 +258,23 @@ func validateConfig(config *Configuration) error {
 		return fmt.Errorf("Couldn't load NodeName. Ensure Kubernetes Nodename is given as a parameter")
 	}
 
+	if config.InformerResyncPeriod < 0 {
+		return fmt.Errorf("InformerResyncPeriod should not be negative")
+	}
+
+	if config.SyncTimeout <= 0 {
+		return fmt.Errorf("SyncTimeout should be positive")
+	}
+
+	if config.PolicyWorkers < 1 {
+		return fmt.Errorf("PolicyWorkers should be at least 1")
+	}
+
+	// Validating ENFORCEMENTMODE
+	if config.EnforcementMode != "enforce" && config.EnforcementMode != "audit" {
+		return fmt.Errorf("EnforcementMode should be enforce or audit")
+	}
+
 	// Validating AUTHTYPE
 	if config.AuthType != "PSK" && config.AuthType != "PKI" {
 		return fmt.Errorf("AuthType should be PSK or PKI")
@@ -167,6 +285,60 @@ func validateConfig(config *Configuration) error {
 		return fmt.Errorf("PSK should be provided")
 	}
 
+	config.ParsedExcludedNamespaces = strings.Fields(config.ExcludedNamespaces)
+
+	// Validating COLLECTORTYPE
+	if config.CollectorType == "" {
+		config.CollectorType = "default"
+		if config.CollectorEndpoint != "" {
+			config.CollectorType = "influxdb"
+		}
+	}
+	config.ParsedCollectorType = []string{}
+	collectorTypes := map[string]bool{}
+	for _, collectorType := range strings.Fields(config.CollectorType) {
+		switch collectorType {
+		case "default":
+			continue
+		case "influxdb", "prometheus", "file", "otlp", "webhook":
+		default:
+			return fmt.Errorf("CollectorType should be default, influxdb, prometheus, file, otlp or webhook")
+		}
+		if collectorTypes[collectorType] {
+			return fmt.Errorf("CollectorType %s is given twice", collectorType)
+		}
+		collectorTypes[collectorType] = true
+		config.ParsedCollectorType = append(config.ParsedCollectorType, collectorType)
+	}
+
+	if config.CollectorQueueSize < 1 {
+		return fmt.Errorf("CollectorQueueSize should be at least 1")
+	}
+
+	if config.CollectorSpoolSize < 0 {
+		return fmt.Errorf("CollectorSpoolSize should not be negative")
+	}
+
+	if collectorTypes["prometheus"] && config.HealthAddress == "" {
+		return fmt.Errorf("HealthAddress should be provided to serve the counters of the prometheus collector")
+	}
+
+	if collectorTypes["otlp"] && config.CollectorOTLPEndpoint == "" {
+		return fmt.Errorf("CollectorOTLPEndpoint should be provided")
+	}
+
+	if collectorTypes["otlp"] && config.CollectorOTLPProtocol != "grpc" && config.CollectorOTLPProtocol != "http/json" {
+		return fmt.Errorf("CollectorOTLPProtocol should be grpc or http/json")
+	}
+
+	if collectorTypes["webhook"] && config.CollectorWebhookURL == "" {
+		return fmt.Errorf("CollectorWebhookURL should be provided")
+	}
+
+	if config.CollectorFileMaxSize < 0 || config.CollectorFileMaxAge < 0 || config.CollectorFileMaxBackups < 0 {
+		return fmt.Errorf("CollectorFileMaxSize, CollectorFileMaxAge and CollectorFileMaxBackups should not be negative")
+	}
+
 	parsedTriremeNetworks, err := parseTriremeNets(config.TriremeNetworks)
 	if err != nil {
 		return fmt.Errorf("TargetNetwork is invalid: %s", err)
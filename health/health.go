@@ -0,0 +1,166 @@
+// Package health serves the liveness, readiness and version endpoints of Trireme-Kubernetes.
+// Other endpoints, like the metrics, can be served by the same Server.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-kubernetes/version"
+
+	"go.uber.org/zap"
+)
+
+// Check returns an error if the checked component is not healthy.
+type Check func() error
+
+// Checker is implemented by the components able to report their own health.
+type Checker interface {
+	Check() error
+}
+
+// Server serves /healthz, /readyz and /version.
+// /healthz and /readyz return 200 if all their checks succeed, 503 otherwise.
+type Server struct {
+	address string
+	server  *http.Server
+	mux     *http.ServeMux
+
+	sync.RWMutex
+	livenessChecks  map[string]Check
+	readinessChecks map[string]Check
+}
+
+// NewServer creates a Server listening on address once started.
+func NewServer(address string) *Server {
+	s := &Server{
+		address:         address,
+		mux:             http.NewServeMux(),
+		livenessChecks:  map[string]Check{},
+		readinessChecks: map[string]Check{},
+	}
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, s.livenessChecks)
+	})
+	s.mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, s.readinessChecks)
+	})
+	s.mux.HandleFunc("/version", serveVersion)
+	s.server = &http.Server{Handler: s.mux}
+	return s
+}
+
+// AddLivenessCheck adds a check to /healthz. A failing liveness check means the process needs to be restarted.
+func (s *Server) AddLivenessCheck(name string, check Check) {
+	s.Lock()
+	defer s.Unlock()
+	s.livenessChecks[name] = check
+}
+
+// AddReadinessCheck adds a check to /readyz. A failing readiness check means the process is not done starting.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.Lock()
+	defer s.Unlock()
+	s.readinessChecks[name] = check
+}
+
+// Handle serves an additional endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the http.Handler serving the endpoints.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start starts listening. The endpoints are served in the background until Stop is called.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("Couldn't listen on %s: %s", s.address, err)
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("Health server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Stop stops the server, waiting for the pending requests until ctx is done.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) serveChecks(w http.ResponseWriter, checks map[string]Check) {
+	s.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	s.RUnlock()
+	sort.Strings(names)
+
+	failed := false
+	body := ""
+	for _, name := range names {
+		s.RLock()
+		check := checks[name]
+		s.RUnlock()
+
+		if err := check(); err != nil {
+			failed = true
+			body += fmt.Sprintf("%s: %s\n", name, err)
+			continue
+		}
+		body += fmt.Sprintf("%s: ok\n", name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, body)
+}
+
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"version":  version.VERSION,
+		"revision": version.REVISION,
+	}); err != nil {
+		zap.L().Debug("Couldn't write version", zap.Error(err))
+	}
+}
+
+// Condition is a Check failing until the condition is met.
+type Condition struct {
+	sync.RWMutex
+	err error
+}
+
+// NewCondition creates a Condition that is not met, with pending as reason.
+func NewCondition(pending string) *Condition {
+	return &Condition{err: fmt.Errorf("%s", pending)}
+}
+
+// Set sets the state of the Condition. A nil err means the condition is met.
+func (c *Condition) Set(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.err = err
+}
+
+// Check returns nil if the condition is met.
+func (c *Condition) Check() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.err
+}
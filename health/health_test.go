@@ -0,0 +1,67 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-kubernetes/version"
+)
+
+func TestChecks(t *testing.T) {
+	s := NewServer("")
+	s.AddLivenessCheck("resolver", func() error { return nil })
+	s.AddReadinessCheck("resolver", func() error { return nil })
+	s.AddReadinessCheck("collector", func() error { return fmt.Errorf("not connected") })
+
+	tests := []struct {
+		path     string
+		status   int
+		contains []string
+	}{
+		{path: "/healthz", status: http.StatusOK, contains: []string{"resolver: ok"}},
+		{path: "/readyz", status: http.StatusServiceUnavailable, contains: []string{"resolver: ok", "collector: not connected"}},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		s.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, recorder.Code)
+		}
+		for _, expected := range test.contains {
+			if !strings.Contains(recorder.Body.String(), expected) {
+				t.Errorf("%s: expected %q in %q", test.path, expected, recorder.Body.String())
+			}
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	NewServer("").Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/version", nil))
+
+	v := map[string]string{}
+	if err := json.NewDecoder(recorder.Body).Decode(&v); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if v["version"] != version.VERSION || v["revision"] != version.REVISION {
+		t.Errorf("Unexpected version %v", v)
+	}
+}
+
+func TestCondition(t *testing.T) {
+	c := NewCondition("not started")
+	if err := c.Check(); err == nil || err.Error() != "not started" {
+		t.Errorf("Expected pending condition, got %v", err)
+	}
+
+	c.Set(nil)
+	if err := c.Check(); err != nil {
+		t.Errorf("Expected met condition, got %s", err)
+	}
+}
@@ -1,36 +1,110 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/aporeto-inc/kubepox"
+	"sort"
+	"time"
 
 	api "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Client is the Trireme representation of the Client.
+// Pods, Namespaces, NetworkPolicies, Services and ReplicaSets are read from the local caches of shared informers.
 type Client struct {
 	kubeClient kubernetes.Interface
 	localNode  string
+
+	informerFactory       informers.SharedInformerFactory
+	podInformer           cache.SharedIndexInformer
+	podLister             corelisters.PodLister
+	namespaceInformer     cache.SharedIndexInformer
+	namespaceLister       corelisters.NamespaceLister
+	networkPolicyInformer cache.SharedIndexInformer
+	networkPolicyLister   networkinglisters.NetworkPolicyLister
+	serviceInformer       cache.SharedIndexInformer
+	serviceLister         corelisters.ServiceLister
+	replicaSetInformer    cache.SharedIndexInformer
+	replicaSetLister      appslisters.ReplicaSetLister
+	// podServices caches the result of PodServices.
+	podServices *podServicesCache
 }
 
 // NewClient Generate and initialize a Trireme Client object
-func NewClient(kubeconfig string, nodename string) (*Client, error) {
+// resync is the interval at which the informers replay their whole cache as updates. 0 disables the resync.
+func NewClient(kubeconfig string, nodename string, resync time.Duration) (*Client, error) {
 	Client := &Client{}
-	Client.localNode = nodename
 
 	if err := Client.InitKubernetesClient(kubeconfig); err != nil {
 		return nil, fmt.Errorf("Couldn't initialize Kubernetes Client: %v", err)
 	}
+
+	return NewClientFromInterface(Client.kubeClient, nodename, resync)
+}
+
+// NewClientFromInterface generates a Trireme Client object from an existing Kubernetes clientset.
+// NewClient uses it once the clientset is built from the kubeconfig. It is exported for the packages
+// running on a fake clientset in their tests, such as the resolver: a constructor in an export_test.go
+// file is only visible to the tests of this package.
+// resync is the interval at which the informers replay their whole cache as updates. 0 disables the resync.
+func NewClientFromInterface(kubeClient kubernetes.Interface, nodename string, resync time.Duration) (*Client, error) {
+	Client := &Client{
+		kubeClient: kubeClient,
+		localNode:  nodename,
+	}
+
+	if err := Client.initInformers(resync); err != nil {
+		return nil, fmt.Errorf("Couldn't initialize Kubernetes informers: %v", err)
+	}
 	return Client, nil
 }
 
+// initInformers creates the shared informers and listers used to read Pods, Namespaces, NetworkPolicies, Services and ReplicaSets.
+func (c *Client) initInformers(resync time.Duration) error {
+	c.informerFactory = informers.NewSharedInformerFactory(c.kubeClient, resync)
+
+	pods := c.informerFactory.Core().V1().Pods()
+	c.podInformer = pods.Informer()
+	c.podLister = pods.Lister()
+	if err := c.podInformer.AddIndexers(cache.Indexers{nodeNameIndex: podNodeNameIndexFunc, podIPIndex: podIPIndexFunc}); err != nil {
+		return fmt.Errorf("Error adding the indexes to the pod informer: %s", err)
+	}
+
+	namespaces := c.informerFactory.Core().V1().Namespaces()
+	c.namespaceInformer = namespaces.Informer()
+	c.namespaceLister = namespaces.Lister()
+
+	networkPolicies := c.informerFactory.Networking().V1().NetworkPolicies()
+	c.networkPolicyInformer = networkPolicies.Informer()
+	c.networkPolicyLister = networkPolicies.Lister()
+
+	services := c.informerFactory.Core().V1().Services()
+	c.serviceInformer = services.Informer()
+	c.serviceLister = services.Lister()
+
+	replicaSets := c.informerFactory.Apps().V1().ReplicaSets()
+	c.replicaSetInformer = replicaSets.Informer()
+	c.replicaSetLister = replicaSets.Lister()
+
+	c.podServices = newPodServicesCache()
+	c.serviceInformer.AddEventHandler(c.podServices.serviceEventHandler())
+	c.podInformer.AddEventHandler(c.podServices.podEventHandler())
+
+	return nil
+}
+
 // InitKubernetesClient Initialize the Kubernetes client based on the parameter kubeconfig
 // if Kubeconfig is empty, try an in-cluster auth.
 func (c *Client) InitKubernetesClient(kubeconfig string) error {
@@ -59,52 +133,10 @@ func (c *Client) InitKubernetesClient(kubeconfig string) error {
 	return nil
 }
 
-func (c *Client) localNodeSelector() fields.Selector {
-	return fields.Set(map[string]string{
-		"spec.nodeName": c.localNode,
-	}).AsSelector()
-}
-
-func (c *Client) localNodeOption() metav1.ListOptions {
-	return metav1.ListOptions{
-		FieldSelector: c.localNodeSelector().String(),
-	}
-}
-
-// IngressPodRules return the list of all the IngressRules that apply to the pod.
-func (c *Client) IngressPodRules(podName string, namespace string, allPolicies *networking.NetworkPolicyList) (*[]networking.NetworkPolicyIngressRule, error) {
-	// Step1: Get all the rules associated with this Pod.
-	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("Couldn't get pod %v from Kubernetes API: %v", podName, err)
-	}
-
-	allRules, err := kubepox.ListIngressRulesPerPod(targetPod, allPolicies)
-	if err != nil {
-		return nil, fmt.Errorf("Couldn't process the list of rules for pod %v : %v", podName, err)
-	}
-	return allRules, nil
-}
-
-// EgressPodRules return the list of all the IngressRules that apply to the pod.
-func (c *Client) EgressPodRules(podName string, namespace string, allPolicies *networking.NetworkPolicyList) (*[]networking.NetworkPolicyEgressRule, error) {
-	// Step1: Get all the rules associated with this Pod.
-	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("Couldn't get pod %v from Kubernetes API: %v", podName, err)
-	}
-
-	allRules, err := kubepox.ListEgressRulesPerPod(targetPod, allPolicies)
-	if err != nil {
-		return nil, fmt.Errorf("Couldn't process the list of rules for pod %v : %v", podName, err)
-	}
-	return allRules, nil
-}
-
 // Endpoints return the list of all the Endpoints that are serviced by a specific service/namespace.
 func (c *Client) Endpoints(service string, namespace string) (*api.Endpoints, error) {
 	// Step1: Get all the rules associated with this Pod.
-	endpoints, err := c.kubeClient.Core().Endpoints(namespace).Get(service, metav1.GetOptions{})
+	endpoints, err := c.kubeClient.CoreV1().Endpoints(namespace).Get(context.TODO(), service, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get endpoints for service %s from Kubernetes API: %s", service, err)
 	}
@@ -113,7 +145,7 @@ func (c *Client) Endpoints(service string, namespace string) (*api.Endpoints, er
 
 // PodLabels returns the list of all labels associated with a pod.
 func (c *Client) PodLabels(podName string, namespace string) (map[string]string, error) {
-	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
+	targetPod, err := c.podLister.Pods(namespace).Get(podName)
 	if err != nil {
 		return nil, fmt.Errorf("error getting Kubernetes labels for pod %v : %v ", podName, err)
 	}
@@ -122,7 +154,7 @@ func (c *Client) PodLabels(podName string, namespace string) (map[string]string,
 
 // PodIP returns the pod's IP.
 func (c *Client) PodIP(podName string, namespace string) (string, error) {
-	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
+	targetPod, err := c.podLister.Pods(namespace).Get(podName)
 	if err != nil {
 		return "", fmt.Errorf("error getting Kubernetes IP for pod %v : %v ", podName, err)
 	}
@@ -131,7 +163,7 @@ func (c *Client) PodIP(podName string, namespace string) (string, error) {
 
 // PodLabelsAndIP returns the list of all labels associated with a pod as well as the Pod's IP.
 func (c *Client) PodLabelsAndIP(podName string, namespace string) (map[string]string, string, error) {
-	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
+	targetPod, err := c.podLister.Pods(namespace).Get(podName)
 	if err != nil {
 		return nil, "", fmt.Errorf("error getting Kubernetes labels & IP for pod %v : %v ", podName, err)
 	}
@@ -143,28 +175,176 @@ func (c *Client) PodLabelsAndIP(podName string, namespace string) (map[string]st
 }
 
 // Pod returns the full pod object.
+// The pod is read from the API if the informer cache didn't receive it yet, as a container can
+// start before the watch event of its pod. The pod may be shared with the informer cache and must not be modified.
 func (c *Client) Pod(podName string, namespace string) (*api.Pod, error) {
-	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
+	targetPod, err := c.podLister.Pods(namespace).Get(podName)
+	if errors.IsNotFound(err) {
+		targetPod, err = c.kubeClient.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting Kubernetes labels & IP for pod %v : %v ", podName, err)
 	}
 	return targetPod, nil
 }
 
+// CachedPod returns the full pod object from the informer cache only.
+// The pod is shared with the informer cache and must not be modified.
+func (c *Client) CachedPod(podName string, namespace string) (*api.Pod, error) {
+	targetPod, err := c.podLister.Pods(namespace).Get(podName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Kubernetes pod %v : %v ", podName, err)
+	}
+	return targetPod, nil
+}
+
+// PodByIP returns the pod having the given IP. Pods on the host network are never returned.
+// The pod is shared with the informer cache and must not be modified.
+func (c *Client) PodByIP(ip string) (*api.Pod, error) {
+	objs, err := c.podInformer.GetIndexer().ByIndex(podIPIndex, ip)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Kubernetes pod with IP %v : %v ", ip, err)
+	}
+	// IPs are reused: a terminated pod may still have the IP of a running pod.
+	var found *api.Pod
+	for _, obj := range objs {
+		pod, ok := obj.(*api.Pod)
+		if !ok {
+			continue
+		}
+		if pod.Status.Phase != api.PodSucceeded && pod.Status.Phase != api.PodFailed {
+			return pod, nil
+		}
+		found = pod
+	}
+	if found == nil {
+		return nil, fmt.Errorf("No pod with IP %v", ip)
+	}
+	return found, nil
+}
+
+// PodServices returns the sorted names of the Services selecting the pod.
+// The result is cached until the labels of the pod or the Services of its namespace change.
+// The returned slice is shared with the cache and must not be modified.
+func (c *Client) PodServices(pod *api.Pod) ([]string, error) {
+	cached, generation, ok := c.podServices.get(pod)
+	if ok {
+		return cached, nil
+	}
+
+	services, err := c.serviceLister.Services(pod.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get services list : %s", err)
+	}
+	names := []string{}
+	for _, service := range services {
+		// Services without selector have their endpoints managed manually.
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+		if labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(pod.GetLabels())) {
+			names = append(names, service.GetName())
+		}
+	}
+	sort.Strings(names)
+	c.podServices.set(pod, names, generation)
+	return names, nil
+}
+
+// PodWorkload returns the kind and name of the controller owning the pod, ex: Deployment/web.
+// The pods owned by a ReplicaSet are reported with the controller owning the ReplicaSet, if any.
+func (c *Client) PodWorkload(pod *api.Pod) (string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", nil
+	}
+	if owner.Kind != "ReplicaSet" {
+		return owner.Kind + "/" + owner.Name, nil
+	}
+
+	replicaSet, err := c.replicaSetLister.ReplicaSets(pod.GetNamespace()).Get(owner.Name)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't get ReplicaSet %s of pod %s: %s", owner.Name, pod.GetName(), err)
+	}
+	if replicaSetOwner := metav1.GetControllerOf(replicaSet); replicaSetOwner != nil {
+		return replicaSetOwner.Kind + "/" + replicaSetOwner.Name, nil
+	}
+	return owner.Kind + "/" + owner.Name, nil
+}
+
+// IsLocalPod returns true if the pod exists and is scheduled on the local node.
+func (c *Client) IsLocalPod(podName string, namespace string) (bool, error) {
+	targetPod, err := c.podLister.Pods(namespace).Get(podName)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting Kubernetes pod %v : %v ", podName, err)
+	}
+	return targetPod.Spec.NodeName == c.localNode, nil
+}
+
+// Pods return a PodList with all the pods of a namespace, or of all namespaces for metav1.NamespaceAll.
+func (c *Client) Pods(namespace string) (*api.PodList, error) {
+	var pods []*api.Pod
+	var err error
+	if namespace == metav1.NamespaceAll {
+		pods, err = c.podLister.List(labels.Everything())
+	} else {
+		pods, err = c.podLister.Pods(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get pods list : %s", err)
+	}
+	return podList(pods), nil
+}
+
 // LocalPods return a PodList with all the pods scheduled on the local node
 func (c *Client) LocalPods(namespace string) (*api.PodList, error) {
-	return c.kubeClient.Core().Pods(namespace).List(c.localNodeOption())
+	objs, err := c.podInformer.GetIndexer().ByIndex(nodeNameIndex, c.localNode)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get local pods list : %s", err)
+	}
+	pods := []*api.Pod{}
+	for _, obj := range objs {
+		pod, ok := obj.(*api.Pod)
+		if !ok {
+			continue
+		}
+		if namespace != metav1.NamespaceAll && pod.GetNamespace() != namespace {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return podList(pods), nil
+}
+
+// podList wraps pods from the informer cache into a PodList.
+func podList(pods []*api.Pod) *api.PodList {
+	list := &api.PodList{Items: make([]api.Pod, 0, len(pods))}
+	for _, pod := range pods {
+		list.Items = append(list.Items, *pod)
+	}
+	return list
 }
 
 // AllNamespaces return a list of all existing namespaces
 func (c *Client) AllNamespaces() (*api.NamespaceList, error) {
-	return c.kubeClient.Core().Namespaces().List(metav1.ListOptions{})
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get namespaces list : %s", err)
+	}
+	list := &api.NamespaceList{Items: make([]api.Namespace, 0, len(namespaces))}
+	for _, namespace := range namespaces {
+		list.Items = append(list.Items, *namespace)
+	}
+	return list, nil
 }
 
 // AddLocalNodeAnnotation adds the annotationKey:annotationValue
 func (c *Client) AddLocalNodeAnnotation(annotationKey, annotationValue string) error {
 	nodeName := c.localNode
-	node, err := c.kubeClient.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+	node, err := c.kubeClient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("Couldn't get node %s: %s", nodeName, err)
 	}
@@ -172,7 +352,7 @@ func (c *Client) AddLocalNodeAnnotation(annotationKey, annotationValue string) e
 	annotations := node.GetAnnotations()
 	annotations[annotationKey] = annotationValue
 	node.SetAnnotations(annotations)
-	_, err = c.kubeClient.Core().Nodes().Update(node)
+	_, err = c.kubeClient.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("Error updating Annotations for node %s: %s", nodeName, err)
 	}
@@ -181,20 +361,30 @@ func (c *Client) AddLocalNodeAnnotation(annotationKey, annotationValue string) e
 
 // AllNodes return a list of all the nodes on the KubeCluster.
 func (c *Client) AllNodes() (*api.NodeList, error) {
-	nodes, err := c.kubeClient.Core().Nodes().List(metav1.ListOptions{})
+	nodes, err := c.kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get nodes list : %s", err)
 	}
 	return nodes, nil
 }
 
-// NetworkPolicies return a list of all the networkpolicies in a specific namespace
+// NetworkPolicies return a list of all the networkpolicies in a specific namespace, or of all namespaces for metav1.NamespaceAll.
 func (c *Client) NetworkPolicies(namespace string) (*networking.NetworkPolicyList, error) {
-	nps, err := c.kubeClient.Networking().NetworkPolicies(namespace).List(metav1.ListOptions{})
+	var nps []*networking.NetworkPolicy
+	var err error
+	if namespace == metav1.NamespaceAll {
+		nps, err = c.networkPolicyLister.List(labels.Everything())
+	} else {
+		nps, err = c.networkPolicyLister.NetworkPolicies(namespace).List(labels.Everything())
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get nertworkpolicies list : %s", err)
 	}
-	return nps, nil
+	list := &networking.NetworkPolicyList{Items: make([]networking.NetworkPolicy, 0, len(nps))}
+	for _, np := range nps {
+		list.Items = append(list.Items, *np)
+	}
+	return list, nil
 }
 
 // KubeClient returns the Kubernetes ClientSet
@@ -0,0 +1,295 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testClient(t *testing.T, pods ...*api.Pod) *Client {
+	c := &Client{
+		kubeClient: fake.NewSimpleClientset(),
+		localNode:  "node-1",
+	}
+	if err := c.initInformers(0); err != nil {
+		t.Fatalf("Couldn't initialize informers: %s", err)
+	}
+	for _, pod := range pods {
+		if err := c.podInformer.GetIndexer().Add(pod); err != nil {
+			t.Fatalf("Couldn't add pod: %s", err)
+		}
+	}
+	return c
+}
+
+func testPod(name, namespace, nodeName string) *api.Pod {
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       api.PodSpec{NodeName: nodeName},
+	}
+}
+
+func podNames(pods *api.PodList) map[string]bool {
+	names := map[string]bool{}
+	for _, pod := range pods.Items {
+		names[pod.Namespace+"/"+pod.Name] = true
+	}
+	return names
+}
+
+func TestLocalPods(t *testing.T) {
+	c := testClient(t,
+		testPod("web-0", "default", "node-1"),
+		testPod("web-1", "default", "node-2"),
+		testPod("db-0", "payments", "node-1"),
+		testPod("pending", "default", ""),
+	)
+
+	tests := []struct {
+		namespace string
+		expected  []string
+	}{
+		{namespace: metav1.NamespaceAll, expected: []string{"default/web-0", "payments/db-0"}},
+		{namespace: "default", expected: []string{"default/web-0"}},
+		{namespace: "monitoring", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		pods, err := c.LocalPods(test.namespace)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		names := podNames(pods)
+		if len(names) != len(test.expected) {
+			t.Errorf("Namespace %q: expected %v, got %v", test.namespace, test.expected, names)
+			continue
+		}
+		for _, name := range test.expected {
+			if !names[name] {
+				t.Errorf("Namespace %q: expected %s in %v", test.namespace, name, names)
+			}
+		}
+	}
+}
+
+func TestPods(t *testing.T) {
+	c := testClient(t,
+		testPod("web-0", "default", "node-1"),
+		testPod("web-1", "default", "node-2"),
+		testPod("db-0", "payments", "node-1"),
+	)
+
+	all, err := c.Pods(metav1.NamespaceAll)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(all.Items) != 3 {
+		t.Errorf("Expected 3 pods in all namespaces, got %v", podNames(all))
+	}
+
+	namespaced, err := c.Pods("default")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(namespaced.Items) != 2 {
+		t.Errorf("Expected 2 pods in default, got %v", podNames(namespaced))
+	}
+}
+
+func TestIsLocalPod(t *testing.T) {
+	c := testClient(t,
+		testPod("web-0", "default", "node-1"),
+		testPod("web-1", "default", "node-2"),
+	)
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "web-0", expected: true},
+		{name: "web-1", expected: false},
+		{name: "web-2", expected: false},
+	}
+
+	for _, test := range tests {
+		local, err := c.IsLocalPod(test.name, "default")
+		if err != nil {
+			t.Errorf("Pod %s: unexpected error: %s", test.name, err)
+		}
+		if local != test.expected {
+			t.Errorf("Pod %s: expected %t, got %t", test.name, test.expected, local)
+		}
+	}
+}
+
+func TestPodByIP(t *testing.T) {
+	running := testPod("web-0", "default", "node-1")
+	running.Status = api.PodStatus{PodIP: "10.0.0.1", Phase: api.PodRunning}
+	terminated := testPod("job-0", "default", "node-1")
+	terminated.Status = api.PodStatus{PodIP: "10.0.0.1", Phase: api.PodSucceeded}
+	hostNetwork := testPod("proxy-0", "kube-system", "node-1")
+	hostNetwork.Spec.HostNetwork = true
+	hostNetwork.Status = api.PodStatus{PodIP: "192.168.0.1", Phase: api.PodRunning}
+	c := testClient(t, terminated, running, hostNetwork)
+
+	pod, err := c.PodByIP("10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if pod.GetName() != "web-0" {
+		t.Errorf("Expected the running pod web-0, got %s", pod.GetName())
+	}
+
+	for _, ip := range []string{"192.168.0.1", "10.0.0.2"} {
+		if _, err := c.PodByIP(ip); err == nil {
+			t.Errorf("IP %s: expected an error", ip)
+		}
+	}
+}
+
+func TestPodServices(t *testing.T) {
+	c := testClient(t)
+	services := []*api.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}, Spec: api.ServiceSpec{Selector: map[string]string{"app": "web"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "all", Namespace: "default"}, Spec: api.ServiceSpec{Selector: map[string]string{"tier": "frontend"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}, Spec: api.ServiceSpec{Selector: map[string]string{"app": "db"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "payments"}, Spec: api.ServiceSpec{Selector: map[string]string{"app": "web"}}},
+	}
+	for _, service := range services {
+		if err := c.serviceInformer.GetIndexer().Add(service); err != nil {
+			t.Fatalf("Couldn't add service: %s", err)
+		}
+	}
+
+	pod := testPod("web-0", "default", "node-1")
+	pod.Labels = map[string]string{"app": "web", "tier": "frontend"}
+	names, err := c.PodServices(pod)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(names) != 2 || names[0] != "all" || names[1] != "web" {
+		t.Errorf("Expected services [all web], got %v", names)
+	}
+}
+
+func TestPodServicesCache(t *testing.T) {
+	c := testClient(t)
+	web := &api.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}, Spec: api.ServiceSpec{Selector: map[string]string{"app": "web"}}}
+	if err := c.serviceInformer.GetIndexer().Add(web); err != nil {
+		t.Fatalf("Couldn't add service: %s", err)
+	}
+
+	pod := testPod("web-0", "default", "node-1")
+	pod.Labels = map[string]string{"app": "web"}
+	if names, err := c.PodServices(pod); err != nil || len(names) != 1 {
+		t.Fatalf("Expected services [web], got %v (%v)", names, err)
+	}
+
+	// The Services are served from the cache until a Service event is received.
+	all := &api.Service{ObjectMeta: metav1.ObjectMeta{Name: "all", Namespace: "default"}, Spec: api.ServiceSpec{Selector: map[string]string{"app": "web"}}}
+	if err := c.serviceInformer.GetIndexer().Add(all); err != nil {
+		t.Fatalf("Couldn't add service: %s", err)
+	}
+	if names, _ := c.PodServices(pod); len(names) != 1 {
+		t.Errorf("Expected cached services [web], got %v", names)
+	}
+	c.podServices.serviceEventHandler().OnAdd(all)
+	if names, _ := c.PodServices(pod); len(names) != 2 {
+		t.Errorf("Expected services [all web], got %v", names)
+	}
+
+	// A change of the labels of the pod invalidates its entry.
+	relabeled := pod.DeepCopy()
+	relabeled.Labels = map[string]string{"app": "db"}
+	if names, _ := c.PodServices(relabeled); len(names) != 0 {
+		t.Errorf("Expected no services, got %v", names)
+	}
+
+	c.podServices.podEventHandler().OnDelete(relabeled)
+	if _, _, ok := c.podServices.get(relabeled); ok {
+		t.Errorf("Expected the entry of the deleted pod to be removed")
+	}
+}
+
+func TestPodServicesCacheGeneration(t *testing.T) {
+	c := newPodServicesCache()
+	pod := testPod("web-0", "default", "node-1")
+
+	_, generation, _ := c.get(pod)
+	// A Service event happened while the Services were listed.
+	c.deleteNamespace("default")
+	c.set(pod, []string{"web"}, generation)
+	if _, _, ok := c.get(pod); ok {
+		t.Errorf("Expected Services listed before a Service event not to be cached")
+	}
+}
+
+func TestPodFallsBackToAPI(t *testing.T) {
+	c := testClient(t, testPod("web-0", "default", "node-1"))
+	// web-1 was created but its watch event wasn't received yet.
+	if _, err := c.kubeClient.CoreV1().Pods("default").Create(context.TODO(), testPod("web-1", "default", "node-1"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Couldn't create pod: %s", err)
+	}
+
+	for _, name := range []string{"web-0", "web-1"} {
+		if pod, err := c.Pod(name, "default"); err != nil || pod.GetName() != name {
+			t.Errorf("Pod %s: expected the pod, got %v (%v)", name, pod, err)
+		}
+	}
+	if _, err := c.Pod("web-2", "default"); err == nil {
+		t.Errorf("Expected an error for a pod that doesn't exist")
+	}
+
+	if _, err := c.CachedPod("web-1", "default"); err == nil {
+		t.Errorf("Expected CachedPod not to read the API")
+	}
+}
+
+func TestPodWorkload(t *testing.T) {
+	controller := true
+	c := testClient(t)
+	replicaSets := []*apps.ReplicaSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-5d8f9c6b7", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", Controller: &controller}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}},
+	}
+	for _, replicaSet := range replicaSets {
+		if err := c.replicaSetInformer.GetIndexer().Add(replicaSet); err != nil {
+			t.Fatalf("Couldn't add ReplicaSet: %s", err)
+		}
+	}
+
+	tests := []struct {
+		owner       *metav1.OwnerReference
+		expected    string
+		expectError bool
+	}{
+		{owner: nil, expected: ""},
+		{owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "web-5d8f9c6b7", Controller: &controller}, expected: "Deployment/web"},
+		{owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "api", Controller: &controller}, expected: "ReplicaSet/api"},
+		{owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "missing", Controller: &controller}, expectError: true},
+		{owner: &metav1.OwnerReference{Kind: "StatefulSet", Name: "db", Controller: &controller}, expected: "StatefulSet/db"},
+		{owner: &metav1.OwnerReference{Kind: "StatefulSet", Name: "db"}, expected: ""},
+	}
+
+	for _, test := range tests {
+		pod := testPod("web-0", "default", "node-1")
+		if test.owner != nil {
+			pod.OwnerReferences = []metav1.OwnerReference{*test.owner}
+		}
+		workload, err := c.PodWorkload(pod)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected an error for the owner %v", test.owner)
+			}
+			continue
+		}
+		if err != nil || workload != test.expected {
+			t.Errorf("Expected %q, got %q (%v)", test.expected, workload, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package kubernetes
+
+import (
+	"sync"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"go.uber.org/zap"
+)
+
+// nodeNameIndex indexes the pods by the node they are scheduled on.
+const nodeNameIndex = "nodeName"
+
+// podNodeNameIndexFunc returns the node on which a pod is scheduled.
+func podNodeNameIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*api.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return []string{}, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// podIPIndex indexes the pods by their IP.
+const podIPIndex = "podIP"
+
+// podIPIndexFunc returns the IP of a pod. The pods on the host network share the IP of their node and are not indexed.
+func podIPIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*api.Pod)
+	if !ok || pod.Status.PodIP == "" || pod.Spec.HostNetwork {
+		return []string{}, nil
+	}
+	return []string{pod.Status.PodIP}, nil
+}
+
+// deletedObject returns the object of a delete event. The object is unwrapped if the
+// informer missed the deletion and only knows the last state of the object.
+func deletedObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+// Run runs the shared informers until stop is closed. The listers of the Client are populated once HasSynced returns true.
+// Run is blocking and returns once all the informers and their event handlers returned.
+func (c *Client) Run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, informer := range []cache.SharedIndexInformer{c.podInformer, c.namespaceInformer, c.networkPolicyInformer, c.serviceInformer, c.replicaSetInformer} {
+		wg.Add(1)
+		go func(informer cache.SharedIndexInformer) {
+			defer wg.Done()
+			informer.Run(stop)
+		}(informer)
+	}
+	wg.Wait()
+}
+
+// HasSynced returns true once the Pod, Namespace, NetworkPolicy, Service and ReplicaSet listers did their initial sync.
+func (c *Client) HasSynced() bool {
+	return c.podInformer.HasSynced() && c.namespaceInformer.HasSynced() && c.networkPolicyInformer.HasSynced() && c.serviceInformer.HasSynced() && c.replicaSetInformer.HasSynced()
+}
+
+// AddNamespaceEventHandler registers the functions called on Namespace events.
+// Handlers must be registered before the Client is run.
+func (c *Client) AddNamespaceEventHandler(
+	addFunc func(addedApiStruct *api.Namespace) error, deleteFunc func(deletedApiStruct *api.Namespace) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Namespace) error) {
+
+	c.namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(addedApiStruct interface{}) {
+			if err := addFunc(addedApiStruct.(*api.Namespace)); err != nil {
+				zap.L().Error("Error while handling Add NameSpace", zap.Error(err))
+			}
+		},
+		DeleteFunc: func(deletedApiStruct interface{}) {
+			deletedNS, ok := deletedObject(deletedApiStruct).(*api.Namespace)
+			if !ok {
+				return
+			}
+			if err := deleteFunc(deletedNS); err != nil {
+				zap.L().Error("Error while handling Delete NameSpace", zap.Error(err))
+			}
+		},
+		UpdateFunc: func(oldApiStruct, updatedApiStruct interface{}) {
+			if err := updateFunc(oldApiStruct.(*api.Namespace), updatedApiStruct.(*api.Namespace)); err != nil {
+				zap.L().Error("Error while handling Update NameSpace", zap.Error(err))
+			}
+		},
+	})
+}
+
+// AddPodEventHandler registers the functions called on Pod events, independently of the node the pods are scheduled on.
+// Handlers must be registered before the Client is run.
+func (c *Client) AddPodEventHandler(
+	addFunc func(addedApiStruct *api.Pod) error, deleteFunc func(deletedApiStruct *api.Pod) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Pod) error) {
+
+	c.podInformer.AddEventHandler(podEventHandler(addFunc, deleteFunc, updateFunc))
+}
+
+// AddLocalPodEventHandler registers the functions called on events of the Pods scheduled on the local node.
+// Handlers must be registered before the Client is run.
+func (c *Client) AddLocalPodEventHandler(
+	addFunc func(addedApiStruct *api.Pod) error, deleteFunc func(deletedApiStruct *api.Pod) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Pod) error) {
+
+	c.podInformer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			pod, ok := deletedObject(obj).(*api.Pod)
+			return ok && pod.Spec.NodeName == c.localNode
+		},
+		Handler: podEventHandler(addFunc, deleteFunc, updateFunc),
+	})
+}
+
+func podEventHandler(
+	addFunc func(addedApiStruct *api.Pod) error, deleteFunc func(deletedApiStruct *api.Pod) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Pod) error) cache.ResourceEventHandler {
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(addedApiStruct interface{}) {
+			if err := addFunc(addedApiStruct.(*api.Pod)); err != nil {
+				zap.L().Error("Error while handling Add Pod", zap.Error(err))
+			}
+		},
+		DeleteFunc: func(deletedApiStruct interface{}) {
+			deletedPod, ok := deletedObject(deletedApiStruct).(*api.Pod)
+			if !ok {
+				return
+			}
+			if err := deleteFunc(deletedPod); err != nil {
+				zap.L().Error("Error while handling Delete Pod", zap.Error(err))
+			}
+		},
+		UpdateFunc: func(oldApiStruct, updatedApiStruct interface{}) {
+			if err := updateFunc(oldApiStruct.(*api.Pod), updatedApiStruct.(*api.Pod)); err != nil {
+				zap.L().Error("Error while handling Update Pod", zap.Error(err))
+			}
+		},
+	}
+}
+
+// AddNetworkPolicyEventHandler registers the functions called on NetworkPolicy events.
+// Handlers must be registered before the Client is run.
+func (c *Client) AddNetworkPolicyEventHandler(
+	addFunc func(addedApiStruct *networking.NetworkPolicy) error, deleteFunc func(deletedApiStruct *networking.NetworkPolicy) error, updateFunc func(oldApiStruct, updatedApiStruct *networking.NetworkPolicy) error) {
+
+	c.networkPolicyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(addedApiStruct interface{}) {
+			if err := addFunc(addedApiStruct.(*networking.NetworkPolicy)); err != nil {
+				zap.L().Error("Error while handling Add NetworkPolicy", zap.Error(err))
+			}
+		},
+		DeleteFunc: func(deletedApiStruct interface{}) {
+			deletedNP, ok := deletedObject(deletedApiStruct).(*networking.NetworkPolicy)
+			if !ok {
+				return
+			}
+			if err := deleteFunc(deletedNP); err != nil {
+				zap.L().Error("Error while handling Delete NetworkPolicy", zap.Error(err))
+			}
+		},
+		UpdateFunc: func(oldApiStruct, updatedApiStruct interface{}) {
+			if err := updateFunc(oldApiStruct.(*networking.NetworkPolicy), updatedApiStruct.(*networking.NetworkPolicy)); err != nil {
+				zap.L().Error("Error while handling Update NetworkPolicy", zap.Error(err))
+			}
+		},
+	})
+}
@@ -0,0 +1,104 @@
+package kubernetes
+
+import (
+	"sync"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podServicesEntry keeps the names of the Services selecting a pod with the labels they were matched against.
+type podServicesEntry struct {
+	labels   string
+	services []string
+}
+
+// podServicesCache caches the names of the Services selecting each pod, by namespace and pod name.
+// An entry is only valid for the labels it was computed with. The entries of a namespace are
+// dropped on any Service event of the namespace, and the entry of a pod when the pod is deleted.
+type podServicesCache struct {
+	sync.RWMutex
+	entries map[string]map[string]podServicesEntry
+	// generation is incremented on each Service event, so that Services listed before the event are not cached.
+	generation uint64
+}
+
+func newPodServicesCache() *podServicesCache {
+	return &podServicesCache{
+		entries: map[string]map[string]podServicesEntry{},
+	}
+}
+
+// get returns the cached Services of the pod, if they were computed with its current labels.
+// Otherwise it returns the generation to set the Services with.
+func (c *podServicesCache) get(pod *api.Pod) ([]string, uint64, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	entry, ok := c.entries[pod.GetNamespace()][pod.GetName()]
+	if !ok || entry.labels != labels.Set(pod.GetLabels()).String() {
+		return nil, c.generation, false
+	}
+	return entry.services, c.generation, true
+}
+
+// set caches the Services of the pod, unless a Service event happened since generation was returned by get.
+func (c *podServicesCache) set(pod *api.Pod, services []string, generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if generation != c.generation {
+		return
+	}
+	namespaceEntries, ok := c.entries[pod.GetNamespace()]
+	if !ok {
+		namespaceEntries = map[string]podServicesEntry{}
+		c.entries[pod.GetNamespace()] = namespaceEntries
+	}
+	namespaceEntries[pod.GetName()] = podServicesEntry{
+		labels:   labels.Set(pod.GetLabels()).String(),
+		services: services,
+	}
+}
+
+func (c *podServicesCache) deleteNamespace(namespace string) {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	delete(c.entries, namespace)
+}
+
+func (c *podServicesCache) deletePod(namespace string, podName string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries[namespace], podName)
+	if len(c.entries[namespace]) == 0 {
+		delete(c.entries, namespace)
+	}
+}
+
+// serviceEventHandler drops the cached Services of the pods of the namespace of a Service on any Service event.
+func (c *podServicesCache) serviceEventHandler() cache.ResourceEventHandler {
+	invalidate := func(obj interface{}) {
+		if service, ok := deletedObject(obj).(*api.Service); ok {
+			c.deleteNamespace(service.GetNamespace())
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    invalidate,
+		DeleteFunc: invalidate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			invalidate(newObj)
+		},
+	}
+}
+
+// podEventHandler drops the cached Services of the deleted pods.
+func (c *podServicesCache) podEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if pod, ok := deletedObject(obj).(*api.Pod); ok {
+				c.deletePod(pod.GetNamespace(), pod.GetName())
+			}
+		},
+	}
+}
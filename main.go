@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -12,6 +13,8 @@ import (
 	"github.com/aporeto-inc/trireme-kubernetes/auth"
 	kubecollector "github.com/aporeto-inc/trireme-kubernetes/collector"
 	"github.com/aporeto-inc/trireme-kubernetes/config"
+	"github.com/aporeto-inc/trireme-kubernetes/health"
+	"github.com/aporeto-inc/trireme-kubernetes/metrics"
 	"github.com/aporeto-inc/trireme-kubernetes/resolver"
 	"github.com/aporeto-inc/trireme-kubernetes/utils"
 	"github.com/aporeto-inc/trireme-kubernetes/version"
@@ -26,6 +29,9 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// resolverStopTimeout is the time given to the KubernetesPolicy to stop on shutdown.
+const resolverStopTimeout = 10 * time.Second
+
 func banner(version, revision string) {
 	fmt.Printf(`
 
@@ -55,17 +61,53 @@ func launch(config *config.Configuration) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Serving the health and metrics endpoints. Each component registers its checks once created.
+	var healthServer *health.Server
+	if config.HealthAddress != "" {
+		healthServer = health.NewServer(config.HealthAddress)
+		healthServer.Handle("/metrics", metrics.Handler())
+		if err := healthServer.Start(); err != nil {
+			zap.L().Fatal("Unable to start health server", zap.Error(err))
+		}
+		zap.L().Info("Serving health endpoints", zap.String("address", config.HealthAddress))
+	}
+	secretsLoaded := health.NewCondition("Secrets not loaded")
+	controllerStarted := health.NewCondition("Controller not started")
+	// The controller has no liveness check: it doesn't report its state once running, and a failure to start it is fatal.
+	if healthServer != nil {
+		healthServer.AddReadinessCheck("secrets", secretsLoaded.Check)
+		healthServer.AddReadinessCheck("controller", controllerStarted.Check)
+	}
+
 	// Generate a unique NodeName used internally to Trireme.
 	triremeNodeName := utils.GenerateNodeName(config.KubeNodeName)
 
-	// Setting up the EventCollector based on the user Config
+	// Setting up the EventCollector based on the user Config. The events are dispatched to each of the configured collectors.
+	// The flows are enriched with the Kubernetes metadata from the goroutine of each collector, once the policy resolver is created.
+	podEnricher := kubecollector.NewPodEnricher()
 	var collectorInstance collector.EventCollector
-	if config.CollectorEndpoint != "" {
-		zap.L().Info("Initializing Trireme with InfluxDBCollector")
-		collectorInstance = kubecollector.NewInfluxDBCollector(config.CollectorUser, config.CollectorPass, config.CollectorEndpoint, config.CollectorDB, config.CollectorInsecureSkipVerify)
-	} else {
+	if len(config.ParsedCollectorType) == 0 {
 		zap.L().Info("Initializing Trireme with Default collector")
 		collectorInstance = kubecollector.NewDefaultCollector()
+	} else {
+		sinks := []kubecollector.Sink{}
+		for _, collectorType := range config.ParsedCollectorType {
+			sink, err := newCollector(config, collectorType)
+			if err != nil {
+				zap.L().Fatal("Unable to initialize collector", zap.String("type", collectorType), zap.Error(err))
+			}
+			sinks = append(sinks, kubecollector.Sink{Name: collectorType, Collector: kubecollector.NewEnrichmentCollector(podEnricher, sink)})
+		}
+		var err error
+		collectorInstance, err = kubecollector.NewFanoutCollector(config.CollectorQueueSize, sinks...)
+		if err != nil {
+			zap.L().Fatal("Unable to initialize collector", zap.Error(err))
+		}
+	}
+	collectorCloser, _ := collectorInstance.(io.Closer)
+	collectorInstance = kubecollector.NewMetricsCollector(collectorInstance)
+	if checker, ok := collectorInstance.(health.Checker); ok && healthServer != nil {
+		healthServer.AddReadinessCheck("collector", checker.Check)
 	}
 
 	// Setting up Auth type based on user config.
@@ -90,6 +132,7 @@ func launch(config *config.Configuration) {
 			zap.L().Fatal("error creating PKI Secret for Trireme", zap.Error(err))
 		}
 	}
+	secretsLoaded.Set(nil)
 
 	// Creating the controller
 	controllerOptions := []controller.Option{
@@ -109,10 +152,31 @@ func launch(config *config.Configuration) {
 	}
 
 	// Create New PolicyEngine based on Kubernetes rules.
-	kubernetesPolicyResolver, err := resolver.NewKubernetesPolicy(ctx, ctrl, config.KubeconfigPath, config.KubeNodeName, config.ParsedTriremeNetworks)
+	enforcementMode, err := resolver.ParseEnforcementMode(config.EnforcementMode)
+	if err != nil {
+		zap.L().Fatal("Invalid enforcement mode", zap.Error(err))
+	}
+	if enforcementMode == resolver.EnforcementModeAudit {
+		zap.L().Warn("NetworkPolicies are audited only. Rejected flows are accepted and reported as would-drop")
+	}
+
+	namespaceActivation, err := resolver.NewNamespaceActivation(config.NamespaceSelector, config.ParsedExcludedNamespaces)
+	if err != nil {
+		zap.L().Fatal("Invalid namespace selection", zap.Error(err))
+	}
+
+	kubernetesPolicyResolver, err := resolver.NewKubernetesPolicy(ctx, ctrl, config.KubeconfigPath, config.KubeNodeName, config.ParsedTriremeNetworks, config.InformerResyncPeriod, config.PolicyWorkers, enforcementMode, namespaceActivation)
 	if err != nil {
 		zap.L().Fatal("Error initializing KubernetesPolicy: ", zap.Error(err))
 	}
+	podEnricher.SetPodResolver(kubernetesPolicyResolver)
+	if err := metrics.RegisterResolverStats(kubernetesPolicyResolver); err != nil {
+		zap.L().Fatal("Unable to register resolver metrics", zap.Error(err))
+	}
+	if healthServer != nil {
+		healthServer.AddLivenessCheck("resolver", kubernetesPolicyResolver.Alive)
+		healthServer.AddReadinessCheck("resolver", kubernetesPolicyResolver.CheckReady)
+	}
 
 	// Monitor configuration
 	monitorOptions := []monitor.Options{
@@ -129,15 +193,18 @@ func launch(config *config.Configuration) {
 		zap.L().Fatal("Unable to initialize monitor", zap.Error(err))
 	}
 
-	// Launching Trireme-Kubernetes and the Policy resolver and waiting for thw associarted initial sync to finish theough the chanel
-	// TODO: Use context here instead ?
-	syncChan := make(chan struct{})
-	kubernetesPolicyResolver.Run(syncChan)
-	<-syncChan
+	// Launching the Policy resolver and waiting for its initial sync before starting Trireme.
+	syncCtx, syncCancel := context.WithTimeout(ctx, config.SyncTimeout)
+	err = kubernetesPolicyResolver.Run(syncCtx)
+	syncCancel()
+	if err != nil {
+		zap.L().Fatal("Failed to start KubernetesPolicy", zap.Error(err))
+	}
 
 	if err := ctrl.Run(ctx); err != nil {
 		zap.L().Fatal("Failed to start controller", zap.Error(err))
 	}
+	controllerStarted.Set(nil)
 
 	// Start all the go routines.
 	if err := m.Run(ctx); err != nil {
@@ -162,12 +229,54 @@ func launch(config *config.Configuration) {
 	}
 
 	zap.L().Debug("Stop signal received")
-	kubernetesPolicyResolver.Stop()
-	zap.L().Debug("KubernetesPolicy stopped")
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), resolverStopTimeout)
+	defer stopCancel()
+	if err := kubernetesPolicyResolver.Stop(stopCtx); err != nil {
+		zap.L().Warn("KubernetesPolicy didn't stop cleanly", zap.Error(err))
+	} else {
+		zap.L().Debug("KubernetesPolicy stopped")
+	}
+
+	if collectorCloser != nil {
+		if err := collectorCloser.Close(); err != nil {
+			zap.L().Warn("Collector didn't close cleanly", zap.Error(err))
+		}
+	}
+
+	if healthServer != nil {
+		healthCtx, healthCancel := context.WithTimeout(context.Background(), resolverStopTimeout)
+		defer healthCancel()
+		if err := healthServer.Stop(healthCtx); err != nil {
+			zap.L().Warn("Health server didn't stop cleanly", zap.Error(err))
+		}
+	}
 
 	zap.L().Info("Everything stopped. Bye Kubernetes!")
 }
 
+// newCollector returns the collector of the given type.
+func newCollector(config *config.Configuration, collectorType string) (collector.EventCollector, error) {
+	switch collectorType {
+	case "influxdb":
+		zap.L().Info("Initializing Trireme with InfluxDBCollector")
+		return kubecollector.NewInfluxDBCollector(config.CollectorUser, config.CollectorPass, config.CollectorEndpoint, config.CollectorDB, config.CollectorInsecureSkipVerify, config.CollectorSpoolSize, config.CollectorFailFast)
+	case "prometheus":
+		zap.L().Info("Initializing Trireme with PrometheusCollector")
+		return kubecollector.NewPrometheusCollector(config.CollectorMaxSeries)
+	case "file":
+		zap.L().Info("Initializing Trireme with FileCollector")
+		return kubecollector.NewFileCollector(config.CollectorFilePath, int64(config.CollectorFileMaxSize)*1024*1024, config.CollectorFileMaxAge, config.CollectorFileMaxBackups)
+	case "otlp":
+		zap.L().Info("Initializing Trireme with OTLPCollector")
+		return kubecollector.NewOTLPCollector(config.CollectorOTLPEndpoint, config.CollectorOTLPProtocol, config.KubeNodeName, config.CollectorOTLPBatchSize, config.CollectorOTLPBufferSize, config.CollectorOTLPFlushInterval)
+	case "webhook":
+		zap.L().Info("Initializing Trireme with WebhookCollector")
+		return kubecollector.NewWebhookCollector(config.CollectorWebhookURL)
+	default:
+		return nil, fmt.Errorf("Unknown collector type %s", collectorType)
+	}
+}
+
 // enforce is used when this trireme-kubernetes process is launched in "Enforce" mode.
 // In this mode, the process is typically launched specifically for one single container
 // in a specific Container namespace.
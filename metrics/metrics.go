@@ -0,0 +1,119 @@
+// Package metrics defines the Prometheus metrics of Trireme-Kubernetes.
+// All the metrics are registered in the default Prometheus registry.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "trireme"
+
+// Reasons of the failed policy updates.
+const (
+	ReasonNoController = "no_controller"
+	ReasonNotCached    = "not_cached"
+	ReasonResolution   = "resolution"
+	ReasonEnforcement  = "enforcement"
+)
+
+var (
+	// PolicyResolutionDuration observes how long the resolution of the policy of a pod takes.
+	PolicyResolutionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "policy_resolution_duration_seconds",
+		Help:      "Time taken to resolve the policy of a pod.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+
+	// PolicyUpdates counts the policy updates pushed to running PUs.
+	PolicyUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_updates_total",
+		Help:      "Number of policy updates pushed to running PUs.",
+	})
+
+	// PolicyUpdateErrors counts the failed policy updates by reason.
+	PolicyUpdateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_update_errors_total",
+		Help:      "Number of failed policy updates by reason.",
+	}, []string{"reason"})
+
+	// NetworkPolicyEvents counts the NetworkPolicy events received from Kubernetes by type (add, update, delete).
+	NetworkPolicyEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "networkpolicy_events_total",
+		Help:      "Number of NetworkPolicy events received from Kubernetes by type.",
+	}, []string{"event"})
+
+	// Flows counts the flows reported to the collector by action (accept, reject, would-drop).
+	Flows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flows_total",
+		Help:      "Number of flows reported by the enforcer by action.",
+	}, []string{"action"})
+
+	// CollectorDroppedEvents counts the events dropped by collector sink because its queue or spool was full.
+	CollectorDroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "collector_dropped_events_total",
+		Help:      "Number of events dropped by collector sink because its queue or spool was full.",
+	}, []string{"sink"})
+
+	// CollectorConnected is set to 1 when the collector sink is connected to its backend, 0 otherwise.
+	CollectorConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "collector_connected",
+		Help:      "Whether the collector sink is connected to its backend.",
+	}, []string{"sink"})
+)
+
+func init() {
+	prometheus.MustRegister(PolicyResolutionDuration, PolicyUpdates, PolicyUpdateErrors, NetworkPolicyEvents, Flows, CollectorDroppedEvents, CollectorConnected)
+}
+
+// ResolverStats gives the current state of the policy resolver.
+type ResolverStats interface {
+	// CachedPods returns the number of pods with a PU in the cache.
+	CachedPods() int
+	// ActiveNamespaces returns the number of namespaces in which the NetworkPolicies are enforced.
+	ActiveNamespaces() int
+	// QueuedPods returns the number of pods waiting for a policy update.
+	QueuedPods() int
+}
+
+// RegisterResolverStats exposes the state of the policy resolver as gauges.
+func RegisterResolverStats(stats ResolverStats) error {
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cached_pods",
+			Help:      "Number of pods with a PU in the resolver cache.",
+		}, func() float64 { return float64(stats.CachedPods()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_namespaces",
+			Help:      "Number of namespaces in which the NetworkPolicies are enforced.",
+		}, func() float64 { return float64(stats.ActiveNamespaces()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queued_pods",
+			Help:      "Number of pods waiting for a policy update.",
+		}, func() float64 { return float64(stats.QueuedPods()) }),
+	}
+
+	for _, gauge := range gauges {
+		if err := prometheus.Register(gauge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns the http.Handler serving the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,64 @@
+package resolver
+
+import (
+	"fmt"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespaceActivation selects the namespaces in which the NetworkPolicies are enforced.
+// The pods of the other namespaces are not policed.
+type NamespaceActivation struct {
+	selector labels.Selector
+	excluded map[string]bool
+}
+
+// NewNamespaceActivation creates a NamespaceActivation selecting the namespaces matching the
+// label selector, except the excluded ones. An empty selector matches all the namespaces.
+func NewNamespaceActivation(selector string, excluded []string) (*NamespaceActivation, error) {
+	parsedSelector, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid namespace selector %s: %s", selector, err)
+	}
+
+	excludedNamespaces := map[string]bool{}
+	for _, namespace := range excluded {
+		excludedNamespaces[namespace] = true
+	}
+
+	return &NamespaceActivation{
+		selector: parsedSelector,
+		excluded: excludedNamespaces,
+	}, nil
+}
+
+// isActive returns true if the NetworkPolicies are enforced in the namespace.
+// The NamespaceActivationAnnotation of the namespace overrides the label selector, but not the excluded namespaces.
+func (n *NamespaceActivation) isActive(namespace *api.Namespace) bool {
+	if n.excluded[namespace.GetName()] {
+		return false
+	}
+
+	switch namespace.GetAnnotations()[NamespaceActivationAnnotation] {
+	case NamespaceActivationEnabled:
+		return true
+	case NamespaceActivationDisabled:
+		return false
+	}
+
+	return n.selector.Matches(labels.Set(namespace.GetLabels()))
+}
+
+// isNamespaceActive returns true if the namespace is in allNamespaces and the NetworkPolicies are enforced in it.
+func (n *NamespaceActivation) isNamespaceActive(namespace string, allNamespaces *api.NamespaceList) bool {
+	if allNamespaces == nil {
+		return false
+	}
+	for i := range allNamespaces.Items {
+		if allNamespaces.Items[i].GetName() == namespace {
+			return n.isActive(&allNamespaces.Items[i])
+		}
+	}
+	return false
+}
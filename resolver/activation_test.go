@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceActivation(t *testing.T) {
+	namespace := func(name string, labels map[string]string, activation string) *api.Namespace {
+		ns := &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+		if activation != "" {
+			ns.Annotations = map[string]string{NamespaceActivationAnnotation: activation}
+		}
+		return ns
+	}
+
+	tests := []struct {
+		name      string
+		selector  string
+		excluded  []string
+		namespace *api.Namespace
+		expected  bool
+	}{
+		{
+			name:      "empty selector matches all",
+			namespace: namespace("default", nil, ""),
+			expected:  true,
+		},
+		{
+			name:      "excluded namespace",
+			excluded:  []string{"kube-system"},
+			namespace: namespace("kube-system", nil, ""),
+			expected:  false,
+		},
+		{
+			name:      "excluded namespace annotated enabled",
+			excluded:  []string{"kube-system"},
+			namespace: namespace("kube-system", nil, NamespaceActivationEnabled),
+			expected:  false,
+		},
+		{
+			name:      "selector matching",
+			selector:  "trireme=enabled",
+			namespace: namespace("payments", map[string]string{"trireme": "enabled"}, ""),
+			expected:  true,
+		},
+		{
+			name:      "selector not matching",
+			selector:  "trireme=enabled",
+			namespace: namespace("payments", map[string]string{"team": "payments"}, ""),
+			expected:  false,
+		},
+		{
+			name:      "annotation opt-in",
+			selector:  "trireme=enabled",
+			namespace: namespace("payments", nil, NamespaceActivationEnabled),
+			expected:  true,
+		},
+		{
+			name:      "annotation opt-out",
+			namespace: namespace("payments", nil, NamespaceActivationDisabled),
+			expected:  false,
+		},
+	}
+
+	for _, test := range tests {
+		activation, err := NewNamespaceActivation(test.selector, test.excluded)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", test.name, err)
+		}
+		if active := activation.isActive(test.namespace); active != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, active)
+		}
+	}
+}
+
+func TestNamespaceActivationInvalidSelector(t *testing.T) {
+	if _, err := NewNamespaceActivation("trireme in (", nil); err == nil {
+		t.Errorf("expected an error for an invalid selector")
+	}
+}
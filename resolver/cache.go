@@ -2,7 +2,9 @@ package resolver
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"go.aporeto.io/trireme-lib/policy"
 )
@@ -10,21 +12,28 @@ import (
 type podCacheEntry struct {
 	contextID string
 	runtime   policy.RuntimeReader
+	// runtimeLabels are the labels of the pod from which the runtime tags were generated.
+	runtimeLabels map[string]string
+	// added is the time at which the pod was added to the cache.
+	added time.Time
 }
 
 // Cache keeps all the state needed for the integration.
 type cacheStruct struct {
-	// namespaceActivation is a map between the namespaceName and the corresponding Watcher struct.
-	namespaceActivation map[string]*NamespaceWatcher
+	// namespaceActivation keeps the names of the namespaces in which the NetworkPolicies are enforced.
+	namespaceActivation map[string]bool
 	// contextIDCache keeps a mapping between a POD/Namespace name and the corresponding contextID from Trireme.
 	podCache map[string]podCacheEntry
+	// contextIDIndex is the reverse index of podCache, from the contextID to the pod identifier.
+	contextIDIndex map[string]string
 	sync.RWMutex
 }
 
 func newCache() *cacheStruct {
 	return &cacheStruct{
-		namespaceActivation: map[string]*NamespaceWatcher{},
+		namespaceActivation: map[string]bool{},
 		podCache:            map[string]podCacheEntry{},
+		contextIDIndex:      map[string]string{},
 	}
 }
 
@@ -36,9 +45,22 @@ func (c *cacheStruct) addPodToCache(contextID string, runtime policy.RuntimeRead
 	c.Lock()
 	defer c.Unlock()
 	kubeIdentifier := kubePodIdentifier(podName, podNamespace)
+	if previous, ok := c.podCache[kubeIdentifier]; ok {
+		c.deleteContextID(previous.contextID, kubeIdentifier)
+	}
 	c.podCache[kubeIdentifier] = podCacheEntry{
 		contextID: contextID,
 		runtime:   runtime,
+		added:     time.Now(),
+	}
+	c.contextIDIndex[contextID] = kubeIdentifier
+}
+
+// deleteContextID removes contextID from the reverse index if it still points to kubeIdentifier.
+// The cache must be locked.
+func (c *cacheStruct) deleteContextID(contextID string, kubeIdentifier string) {
+	if c.contextIDIndex[contextID] == kubeIdentifier {
+		delete(c.contextIDIndex, contextID)
 	}
 }
 
@@ -53,6 +75,18 @@ func (c *cacheStruct) contextIDByPodName(podName string, podNamespace string) (s
 	return cacheEntry.contextID, nil
 }
 
+// podNameByContextID returns the name and namespace of the pod of the PU.
+func (c *cacheStruct) podNameByContextID(contextID string) (string, string, error) {
+	c.RLock()
+	defer c.RUnlock()
+	kubeIdentifier, ok := c.contextIDIndex[contextID]
+	if !ok {
+		return "", "", fmt.Errorf("ContextID %v not found in Cache", contextID)
+	}
+	parts := strings.SplitN(kubeIdentifier, "/", 2)
+	return parts[1], parts[0], nil
+}
+
 func (c *cacheStruct) runtimeByPodName(podName string, podNamespace string) (policy.RuntimeReader, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -64,45 +98,93 @@ func (c *cacheStruct) runtimeByPodName(podName string, podNamespace string) (pol
 	return cacheEntry.runtime, nil
 }
 
+// runtimeLabelsByPodName returns the labels from which the runtime tags of the pod were generated.
+// The first time it is called for a pod, podLabels are recorded as those labels.
+func (c *cacheStruct) runtimeLabelsByPodName(podName string, podNamespace string, podLabels map[string]string) map[string]string {
+	c.Lock()
+	defer c.Unlock()
+	kubeIdentifier := kubePodIdentifier(podName, podNamespace)
+	cacheEntry, ok := c.podCache[kubeIdentifier]
+	if !ok {
+		return podLabels
+	}
+	if cacheEntry.runtimeLabels == nil {
+		cacheEntry.runtimeLabels = podLabels
+		c.podCache[kubeIdentifier] = cacheEntry
+	}
+	return cacheEntry.runtimeLabels
+}
+
 func (c *cacheStruct) deleteFromCacheByPodName(podName string, podNamespace string) error {
 	c.Lock()
 	defer c.Unlock()
 	kubeIdentifier := kubePodIdentifier(podName, podNamespace)
-	_, ok := c.podCache[kubeIdentifier]
+	cacheEntry, ok := c.podCache[kubeIdentifier]
 	if !ok {
 		return fmt.Errorf("Pod %v not found in Cache", kubeIdentifier)
 	}
 	delete(c.podCache, kubeIdentifier)
+	c.deleteContextID(cacheEntry.contextID, kubeIdentifier)
 	return nil
 }
 
-func (c *cacheStruct) getNamespaceWatcher(namespace string) (*NamespaceWatcher, bool) {
+// deleteFromCacheByContextID removes the pod of the PU from the cache. The pod is kept
+// if it has been assigned another contextID since (the PU was restarted).
+func (c *cacheStruct) deleteFromCacheByContextID(contextID string) error {
 	c.Lock()
 	defer c.Unlock()
-	namespaceWatcher, ok := c.namespaceActivation[namespace]
-	return namespaceWatcher, ok
+	kubeIdentifier, ok := c.contextIDIndex[contextID]
+	if !ok {
+		return fmt.Errorf("ContextID %v not found in Cache", contextID)
+	}
+	delete(c.podCache, kubeIdentifier)
+	delete(c.contextIDIndex, contextID)
+	return nil
 }
 
-func (c *cacheStruct) activateNamespaceWatcher(namespace string, namespaceWatcher *NamespaceWatcher) {
+// garbageCollect removes from the cache the pods added before addedBefore for which scheduled returns false.
+// It returns the identifiers of the removed pods.
+func (c *cacheStruct) garbageCollect(scheduled func(kubeIdentifier string) bool, addedBefore time.Time) []string {
 	c.Lock()
 	defer c.Unlock()
-	c.namespaceActivation[namespace] = namespaceWatcher
+	removed := []string{}
+	for kubeIdentifier, cacheEntry := range c.podCache {
+		if cacheEntry.added.After(addedBefore) || scheduled(kubeIdentifier) {
+			continue
+		}
+		delete(c.podCache, kubeIdentifier)
+		c.deleteContextID(cacheEntry.contextID, kubeIdentifier)
+		removed = append(removed, kubeIdentifier)
+	}
+	return removed
 }
 
-func (c *cacheStruct) deactivateNamespaceWatcher(namespace string) {
+func (c *cacheStruct) activateNamespace(namespace string) {
+	c.Lock()
+	defer c.Unlock()
+	c.namespaceActivation[namespace] = true
+}
+
+func (c *cacheStruct) deactivateNamespace(namespace string) {
 	c.Lock()
 	defer c.Unlock()
-	namespaceWatcher, ok := c.namespaceActivation[namespace]
-	if !ok {
-		return
-	}
-	namespaceWatcher.stopWatchingNamespace()
 	delete(c.namespaceActivation, namespace)
 }
 
 func (c *cacheStruct) isNamespaceActive(namespace string) bool {
 	c.Lock()
 	defer c.Unlock()
-	_, ok := c.namespaceActivation[namespace]
-	return ok
+	return c.namespaceActivation[namespace]
+}
+
+func (c *cacheStruct) podCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.podCache)
+}
+
+func (c *cacheStruct) activeNamespaceCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.namespaceActivation)
 }
@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeleteFromCacheByContextID(t *testing.T) {
+	c := newCache()
+	c.addPodToCache("abc", nil, "web-0", "default")
+
+	// The pod was restarted with a new PU before the old one got destroyed.
+	c.addPodToCache("def", nil, "web-0", "default")
+	if err := c.deleteFromCacheByContextID("abc"); err == nil {
+		t.Errorf("Expected an error when deleting a replaced contextID")
+	}
+	if contextID, err := c.contextIDByPodName("web-0", "default"); err != nil || contextID != "def" {
+		t.Errorf("Expected pod to be kept with contextID def, got %q (%v)", contextID, err)
+	}
+
+	if err := c.deleteFromCacheByContextID("def"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if _, err := c.contextIDByPodName("web-0", "default"); err == nil {
+		t.Errorf("Expected pod to be removed from cache")
+	}
+}
+
+func TestGarbageCollect(t *testing.T) {
+	c := newCache()
+	c.addPodToCache("abc", nil, "web-0", "default")
+	c.addPodToCache("def", nil, "web-1", "default")
+	c.addPodToCache("ghi", nil, "web-2", "default")
+
+	// web-2 was added after the pods were listed.
+	entry := c.podCache[kubePodIdentifier("web-2", "default")]
+	entry.added = time.Now().Add(time.Hour)
+	c.podCache[kubePodIdentifier("web-2", "default")] = entry
+
+	scheduled := func(kubeIdentifier string) bool {
+		return kubeIdentifier == kubePodIdentifier("web-0", "default")
+	}
+	removed := c.garbageCollect(scheduled, time.Now())
+	if len(removed) != 1 || removed[0] != kubePodIdentifier("web-1", "default") {
+		t.Errorf("Expected only default/web-1 to be removed, got %v", removed)
+	}
+
+	for _, podName := range []string{"web-0", "web-2"} {
+		if _, err := c.contextIDByPodName(podName, "default"); err != nil {
+			t.Errorf("Expected %s to be kept: %s", podName, err)
+		}
+	}
+}
+
+func TestPodNameByContextID(t *testing.T) {
+	c := newCache()
+	c.addPodToCache("abc", nil, "web-0", "default")
+
+	podName, podNamespace, err := c.podNameByContextID("abc")
+	if err != nil || podName != "web-0" || podNamespace != "default" {
+		t.Errorf("Expected default/web-0, got %s/%s (%v)", podNamespace, podName, err)
+	}
+	if _, _, err := c.podNameByContextID("def"); err == nil {
+		t.Errorf("Expected an error for an unknown contextID")
+	}
+
+	// The pod was restarted with a new PU.
+	c.addPodToCache("def", nil, "web-0", "default")
+	if _, _, err := c.podNameByContextID("abc"); err == nil {
+		t.Errorf("Expected an error for a replaced contextID")
+	}
+	if podName, _, err := c.podNameByContextID("def"); err != nil || podName != "web-0" {
+		t.Errorf("Expected web-0 for the new contextID, got %s (%v)", podName, err)
+	}
+
+	if err := c.deleteFromCacheByPodName("web-0", "default"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if _, _, err := c.podNameByContextID("def"); err == nil {
+		t.Errorf("Expected an error for the contextID of a deleted pod")
+	}
+	if len(c.contextIDIndex) != 0 {
+		t.Errorf("Expected an empty contextID index, got %v", c.contextIDIndex)
+	}
+}
@@ -5,3 +5,27 @@ const UpstreamNameIdentifier = "k8s:name"
 
 // UpstreamNamespaceIdentifier is the identifier used to identify the nanespace on the resulting PU
 const UpstreamNamespaceIdentifier = "k8s:namespace"
+
+// PortIdentifier is the system identifier carrying the destination port of a flow
+const PortIdentifier = "$sys:port"
+
+// EnforcementModeAnnotation is the namespace annotation overriding the enforcement mode of the pods of the namespace
+const EnforcementModeAnnotation = "trireme.aporeto.com/enforcement-mode"
+
+// WouldDropPolicyID is the PolicyID of the flows accepted only because of the audit enforcement mode
+const WouldDropPolicyID = "would-drop"
+
+// NamespaceActivationAnnotation is the namespace annotation enabling or disabling the enforcement of the NetworkPolicies of the namespace
+const NamespaceActivationAnnotation = "trireme.aporeto.com/networkpolicies"
+
+// NamespaceActivationEnabled is the NamespaceActivationAnnotation value enforcing the NetworkPolicies of the namespace
+const NamespaceActivationEnabled = "enabled"
+
+// NamespaceActivationDisabled is the NamespaceActivationAnnotation value not enforcing the NetworkPolicies of the namespace
+const NamespaceActivationDisabled = "disabled"
+
+// AllowAllPolicyID is the PolicyID of the flows accepted because no NetworkPolicy isolates the pod
+const AllowAllPolicyID = "allow-all"
+
+// DefaultDenyPolicyID is the PolicyID reported for the flows rejected because no rule of the NetworkPolicies isolating the pod allows them
+const DefaultDenyPolicyID = "default-deny"
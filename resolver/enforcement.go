@@ -0,0 +1,84 @@
+package resolver
+
+import (
+	"fmt"
+
+	"go.aporeto.io/trireme-lib/policy"
+	api "k8s.io/api/core/v1"
+
+	"go.uber.org/zap"
+)
+
+// EnforcementMode defines what happens to the flows that are not allowed by the NetworkPolicies.
+type EnforcementMode string
+
+const (
+	// EnforcementModeEnforce rejects the flows that are not allowed by the NetworkPolicies.
+	EnforcementModeEnforce EnforcementMode = "enforce"
+	// EnforcementModeAudit accepts the flows that are not allowed by the NetworkPolicies
+	// and reports them to the collector with the WouldDropPolicyID.
+	EnforcementModeAudit EnforcementMode = "audit"
+)
+
+// ParseEnforcementMode returns the EnforcementMode named by mode.
+func ParseEnforcementMode(mode string) (EnforcementMode, error) {
+	switch EnforcementMode(mode) {
+	case EnforcementModeEnforce, EnforcementModeAudit:
+		return EnforcementMode(mode), nil
+	default:
+		return "", fmt.Errorf("Unknown EnforcementMode %s", mode)
+	}
+}
+
+// namespaceEnforcementMode returns the EnforcementMode of the pods of a namespace.
+// The EnforcementModeAnnotation of the namespace overrides defaultMode.
+func namespaceEnforcementMode(namespace string, allNamespaces *api.NamespaceList, defaultMode EnforcementMode) EnforcementMode {
+	if allNamespaces == nil {
+		return defaultMode
+	}
+	for _, ns := range allNamespaces.Items {
+		if ns.GetName() != namespace {
+			continue
+		}
+		annotation, ok := ns.GetAnnotations()[EnforcementModeAnnotation]
+		if !ok {
+			return defaultMode
+		}
+		mode, err := ParseEnforcementMode(annotation)
+		if err != nil {
+			zap.L().Warn("Invalid enforcement mode annotation. Using default", zap.String("namespace", namespace), zap.Error(err))
+			return defaultMode
+		}
+		return mode
+	}
+	return defaultMode
+}
+
+// auditRules returns the rules with a catch-all rule appended, accepting the flows that
+// would be rejected by the rules. The catch-all rule is used only when no other rule matches.
+func auditRules(rules []policy.TagSelector) []policy.TagSelector {
+	audited := append([]policy.TagSelector{}, rules...)
+	for _, rule := range rulesAllowAll() {
+		rule.Policy.PolicyID = WouldDropPolicyID
+		audited = append(audited, rule)
+	}
+	return audited
+}
+
+// auditACLs returns the ACLs with the rejecting ACLs turned into accepting ones, and catch-all
+// ACLs appended. Both accept the flows that would be rejected by the ACLs.
+func auditACLs(acls []policy.IPRule) []policy.IPRule {
+	audited := make([]policy.IPRule, 0, len(acls))
+	for _, acl := range acls {
+		if acl.Policy != nil && acl.Policy.Action == policy.Reject {
+			acl = ipRule(acl.Address, acl.Port, acl.Protocol, policy.Accept)
+			acl.Policy.PolicyID = WouldDropPolicyID
+		}
+		audited = append(audited, acl)
+	}
+	for _, acl := range aclsAllowAll() {
+		acl.Policy.PolicyID = WouldDropPolicyID
+		audited = append(audited, acl)
+	}
+	return audited
+}
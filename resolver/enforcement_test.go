@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceEnforcementMode(t *testing.T) {
+	allNamespaces := &api.NamespaceList{
+		Items: []api.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "staging", Annotations: map[string]string{EnforcementModeAnnotation: "audit"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "prod", Annotations: map[string]string{EnforcementModeAnnotation: "enforce"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "typo", Annotations: map[string]string{EnforcementModeAnnotation: "audti"}}},
+		},
+	}
+
+	tests := []struct {
+		namespace   string
+		defaultMode EnforcementMode
+		expected    EnforcementMode
+	}{
+		{namespace: "default", defaultMode: EnforcementModeEnforce, expected: EnforcementModeEnforce},
+		{namespace: "default", defaultMode: EnforcementModeAudit, expected: EnforcementModeAudit},
+		{namespace: "staging", defaultMode: EnforcementModeEnforce, expected: EnforcementModeAudit},
+		{namespace: "prod", defaultMode: EnforcementModeAudit, expected: EnforcementModeEnforce},
+		{namespace: "typo", defaultMode: EnforcementModeEnforce, expected: EnforcementModeEnforce},
+		{namespace: "unknown", defaultMode: EnforcementModeAudit, expected: EnforcementModeAudit},
+	}
+
+	for _, test := range tests {
+		mode := namespaceEnforcementMode(test.namespace, allNamespaces, test.defaultMode)
+		if mode != test.expected {
+			t.Errorf("Namespace %s with default %s: expected %s, got %s", test.namespace, test.defaultMode, test.expected, mode)
+		}
+	}
+}
+
+func TestGeneratePUPolicyAudit(t *testing.T) {
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default", Labels: map[string]string{"app": "server"}}}
+	policies := []networking.NetworkPolicy{testPolicy("p", ingressEgress, nil, nil)}
+
+	puPolicy, err := generatePUPolicy(policies, pod, testNamespaces(), testPods, EnforcementModeAudit, policy.NewTagStore(), policy.ExtendedMap{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	// Everything is denied by the NetworkPolicy, so only the would-drop rules are left.
+	for _, rules := range [][]policy.TagSelector{puPolicy.ReceiverRules(), puPolicy.TransmitterRules()} {
+		if len(rules) != 1 || rules[0].Policy.Action != policy.Accept || rules[0].Policy.PolicyID != WouldDropPolicyID {
+			t.Errorf("expected a single would-drop rule, got %v", rules)
+		}
+	}
+	for _, acls := range [][]policy.IPRule{puPolicy.NetworkACLs(), puPolicy.ApplicationACLs()} {
+		if len(acls) == 0 {
+			t.Errorf("expected would-drop ACLs")
+		}
+		for _, acl := range acls {
+			if acl.Policy.Action != policy.Accept || acl.Policy.PolicyID != WouldDropPolicyID {
+				t.Errorf("expected would-drop ACL, got %v", acl)
+			}
+		}
+	}
+}
+
+func TestAuditACLs(t *testing.T) {
+	acls := []policy.IPRule{
+		ipRule("10.0.1.0/24", "0:65535", "TCP", policy.Reject),
+		ipRule("10.0.0.0/16", "0:65535", "TCP", policy.Accept),
+	}
+
+	audited := auditACLs(acls)
+	if len(audited) != len(acls)+len(aclsAllowAll()) {
+		t.Fatalf("expected catch-all ACLs to be appended, got %v", audited)
+	}
+	if audited[0].Address != "10.0.1.0/24" || audited[0].Policy.Action != policy.Accept || audited[0].Policy.PolicyID != WouldDropPolicyID {
+		t.Errorf("expected rejecting ACL to be audited, got %v", audited[0])
+	}
+	if audited[1].Address != "10.0.0.0/16" || audited[1].Policy.Action != policy.Accept || audited[1].Policy.PolicyID != "" {
+		t.Errorf("expected accepting ACL to be kept, got %v", audited[1])
+	}
+	if acls[0].Policy.Action != policy.Reject {
+		t.Errorf("expected original ACLs not to be modified")
+	}
+}
@@ -0,0 +1,168 @@
+package resolver
+
+import (
+	"fmt"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// podNetworkPolicies returns all the NetworkPolicies selecting the pod.
+func podNetworkPolicies(pod *api.Pod, allPolicies *networking.NetworkPolicyList) ([]networking.NetworkPolicy, error) {
+	policies := []networking.NetworkPolicy{}
+	if allPolicies == nil {
+		return policies, nil
+	}
+
+	for _, np := range allPolicies.Items {
+		if np.GetNamespace() != pod.GetNamespace() {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing PodSelector of NetworkPolicy %s: %s", np.GetName(), err)
+		}
+		if selector.Matches(labels.Set(pod.GetLabels())) {
+			policies = append(policies, np)
+		}
+	}
+
+	return policies, nil
+}
+
+// policyTypes returns whether the NetworkPolicy applies to ingress and egress traffic.
+// If policyTypes is not set, Ingress is always applied and Egress only if the policy has egress rules,
+// the same way the API server defaults it.
+func policyTypes(np *networking.NetworkPolicy) (ingress bool, egress bool) {
+	if len(np.Spec.PolicyTypes) == 0 {
+		return true, len(np.Spec.Egress) > 0
+	}
+
+	for _, policyType := range np.Spec.PolicyTypes {
+		switch policyType {
+		case networking.PolicyTypeIngress:
+			ingress = true
+		case networking.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return ingress, egress
+}
+
+// policyIngressRule is an ingress rule of a NetworkPolicy together with its PolicyID.
+type policyIngressRule struct {
+	networking.NetworkPolicyIngressRule
+	id string
+}
+
+// policyEgressRule is an egress rule of a NetworkPolicy together with its PolicyID.
+type policyEgressRule struct {
+	networking.NetworkPolicyEgressRule
+	id string
+}
+
+// policyRuleID returns the stable PolicyID of a rule of a NetworkPolicy, ex: default/web#ingress[0].
+func policyRuleID(np *networking.NetworkPolicy, direction string, index int) string {
+	return fmt.Sprintf("%s/%s#%s[%d]", np.GetNamespace(), np.GetName(), direction, index)
+}
+
+// isolationRules returns the ingress and egress rules of all the NetworkPolicies selecting a pod.
+// A nil list means that the pod is not isolated in that direction and all traffic is allowed.
+// An empty list means that the pod is isolated and all traffic is denied.
+func isolationRules(policies []networking.NetworkPolicy) (*[]policyIngressRule, *[]policyEgressRule) {
+	var ingressRules *[]policyIngressRule
+	var egressRules *[]policyEgressRule
+
+	for i := range policies {
+		ingress, egress := policyTypes(&policies[i])
+
+		if ingress {
+			if ingressRules == nil {
+				ingressRules = &[]policyIngressRule{}
+			}
+			for j, rule := range policies[i].Spec.Ingress {
+				*ingressRules = append(*ingressRules, policyIngressRule{NetworkPolicyIngressRule: rule, id: policyRuleID(&policies[i], "ingress", j)})
+			}
+		}
+
+		if egress {
+			if egressRules == nil {
+				egressRules = &[]policyEgressRule{}
+			}
+			for j, rule := range policies[i].Spec.Egress {
+				*egressRules = append(*egressRules, policyEgressRule{NetworkPolicyEgressRule: rule, id: policyRuleID(&policies[i], "egress", j)})
+			}
+		}
+	}
+
+	return ingressRules, egressRules
+}
+
+// policyPeers returns all the ingress and egress peers of a NetworkPolicy.
+func policyPeers(np *networking.NetworkPolicy) []networking.NetworkPolicyPeer {
+	peers := []networking.NetworkPolicyPeer{}
+	for _, rule := range np.Spec.Ingress {
+		peers = append(peers, rule.From...)
+	}
+	for _, rule := range np.Spec.Egress {
+		peers = append(peers, rule.To...)
+	}
+	return peers
+}
+
+// policySelectsPeerPod returns true if any peer of the NetworkPolicy selects a pod with
+// the labels given in parameter in the namespace given in parameter.
+func policySelectsPeerPod(np *networking.NetworkPolicy, podNamespace string, podLabels map[string]string, allNamespaces *api.NamespaceList) (bool, error) {
+	for _, peer := range policyPeers(np) {
+		if peer.PodSelector == nil {
+			continue
+		}
+
+		namespaces, err := peerNamespaces(peer, np.GetNamespace(), allNamespaces)
+		if err != nil {
+			return false, err
+		}
+		if !containsString(namespaces, podNamespace) {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+		if err != nil {
+			return false, fmt.Errorf("Error while parsing Peer label selector %s", err)
+		}
+		if selector.Matches(labels.Set(podLabels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// policySelectsPeerNamespace returns true if any peer of the NetworkPolicy has a NamespaceSelector
+// matching a namespace with the labels given in parameter.
+func policySelectsPeerNamespace(np *networking.NetworkPolicy, namespaceLabels map[string]string) (bool, error) {
+	for _, peer := range policyPeers(np) {
+		if peer.NamespaceSelector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		if err != nil {
+			return false, fmt.Errorf("Error while parsing Peer namespace selector %s", err)
+		}
+		if selector.Matches(labels.Set(namespaceLabels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
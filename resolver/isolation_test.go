@@ -0,0 +1,461 @@
+package resolver
+
+import (
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testPolicy(name string, policyTypes []networking.PolicyType, ingress []networking.NetworkPolicyIngressRule, egress []networking.NetworkPolicyEgressRule) networking.NetworkPolicy {
+	return networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "server"}},
+			Ingress:     ingress,
+			Egress:      egress,
+			PolicyTypes: policyTypes,
+		},
+	}
+}
+
+var (
+	allowAllIngress = []networking.NetworkPolicyIngressRule{{}}
+	allowAllEgress  = []networking.NetworkPolicyEgressRule{{}}
+	ingressOnly     = []networking.PolicyType{networking.PolicyTypeIngress}
+	egressOnly      = []networking.PolicyType{networking.PolicyTypeEgress}
+	ingressEgress   = []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress}
+)
+
+// notIsolated is the expected number of rules of a direction in which the pod is not isolated.
+const notIsolated = -1
+
+var isolationTests = []struct {
+	name            string
+	policies        []networking.NetworkPolicy
+	expectedIngress int
+	expectedEgress  int
+}{
+	{
+		name:            "no policy",
+		policies:        []networking.NetworkPolicy{},
+		expectedIngress: notIsolated,
+		expectedEgress:  notIsolated,
+	},
+	{
+		name:            "no policyTypes and no rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", nil, nil, nil)},
+		expectedIngress: 0,
+		expectedEgress:  notIsolated,
+	},
+	{
+		name:            "no policyTypes with ingress rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", nil, allowAllIngress, nil)},
+		expectedIngress: 1,
+		expectedEgress:  notIsolated,
+	},
+	{
+		name:            "no policyTypes with egress rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", nil, nil, allowAllEgress)},
+		expectedIngress: 0,
+		expectedEgress:  1,
+	},
+	{
+		name:            "Ingress without rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", ingressOnly, nil, nil)},
+		expectedIngress: 0,
+		expectedEgress:  notIsolated,
+	},
+	{
+		name:            "Ingress ignores egress rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", ingressOnly, allowAllIngress, allowAllEgress)},
+		expectedIngress: 1,
+		expectedEgress:  notIsolated,
+	},
+	{
+		name:            "Egress without rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", egressOnly, nil, nil)},
+		expectedIngress: notIsolated,
+		expectedEgress:  0,
+	},
+	{
+		name:            "Egress ignores ingress rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", egressOnly, allowAllIngress, allowAllEgress)},
+		expectedIngress: notIsolated,
+		expectedEgress:  1,
+	},
+	{
+		name:            "Ingress and Egress without rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", ingressEgress, nil, nil)},
+		expectedIngress: 0,
+		expectedEgress:  0,
+	},
+	{
+		name:            "Ingress and Egress with ingress rules only",
+		policies:        []networking.NetworkPolicy{testPolicy("p", ingressEgress, allowAllIngress, nil)},
+		expectedIngress: 1,
+		expectedEgress:  0,
+	},
+	{
+		name:            "Ingress and Egress with rules",
+		policies:        []networking.NetworkPolicy{testPolicy("p", ingressEgress, allowAllIngress, allowAllEgress)},
+		expectedIngress: 1,
+		expectedEgress:  1,
+	},
+	{
+		name: "Ingress policy and Egress policy",
+		policies: []networking.NetworkPolicy{
+			testPolicy("p1", ingressOnly, allowAllIngress, nil),
+			testPolicy("p2", egressOnly, nil, allowAllEgress),
+		},
+		expectedIngress: 1,
+		expectedEgress:  1,
+	},
+	{
+		name: "rules of several policies are merged",
+		policies: []networking.NetworkPolicy{
+			testPolicy("p1", ingressEgress, allowAllIngress, nil),
+			testPolicy("p2", nil, allowAllIngress, allowAllEgress),
+		},
+		expectedIngress: 2,
+		expectedEgress:  1,
+	},
+}
+
+func TestIsolationRules(t *testing.T) {
+	for _, tt := range isolationTests {
+		ingressRules, egressRules := isolationRules(tt.policies)
+
+		if tt.expectedIngress == notIsolated {
+			if ingressRules != nil {
+				t.Errorf("%s: ingress should not be isolated, got %v", tt.name, *ingressRules)
+			}
+		} else if ingressRules == nil || len(*ingressRules) != tt.expectedIngress {
+			t.Errorf("%s: expected %d ingress rules, got %v", tt.name, tt.expectedIngress, ingressRules)
+		}
+
+		if tt.expectedEgress == notIsolated {
+			if egressRules != nil {
+				t.Errorf("%s: egress should not be isolated, got %v", tt.name, *egressRules)
+			}
+		} else if egressRules == nil || len(*egressRules) != tt.expectedEgress {
+			t.Errorf("%s: expected %d egress rules, got %v", tt.name, tt.expectedEgress, egressRules)
+		}
+	}
+}
+
+func TestPodNetworkPolicies(t *testing.T) {
+	selected := testPolicy("selected", nil, nil, nil)
+	other := testPolicy("other", nil, nil, nil)
+	other.Spec.PodSelector = metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}
+	all := testPolicy("all", nil, nil, nil)
+	all.Spec.PodSelector = metav1.LabelSelector{}
+
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default", Labels: map[string]string{"app": "server"}}}
+	policies, err := podNetworkPolicies(pod, &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{selected, other, all}})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if len(policies) != 2 || policies[0].GetName() != "selected" || policies[1].GetName() != "all" {
+		t.Errorf("expected policies [selected all], got %v", policies)
+	}
+}
+
+func TestGeneratePUPolicyDenyAllEgress(t *testing.T) {
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default", Labels: map[string]string{"app": "server"}}}
+	policies := []networking.NetworkPolicy{testPolicy("p", ingressEgress, allowAllIngress, nil)}
+
+	puPolicy, err := generatePUPolicy(policies, pod, testNamespaces(), testPods, EnforcementModeEnforce, policy.NewTagStore(), policy.ExtendedMap{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if len(puPolicy.TransmitterRules()) != 0 || len(puPolicy.ApplicationACLs()) != 0 {
+		t.Errorf("egress should be denied, got rules %v and ACLs %v", puPolicy.TransmitterRules(), puPolicy.ApplicationACLs())
+	}
+	if len(puPolicy.ReceiverRules()) == 0 || len(puPolicy.NetworkACLs()) == 0 {
+		t.Errorf("ingress should be allowed, got rules %v and ACLs %v", puPolicy.ReceiverRules(), puPolicy.NetworkACLs())
+	}
+}
+
+func TestGeneratePUPolicyPolicyIDs(t *testing.T) {
+	port80 := intstr.FromInt(80)
+	ingress := []networking.NetworkPolicyIngressRule{
+		{
+			Ports: []networking.NetworkPolicyPort{{Port: &port80}},
+		},
+		{
+			From: []networking.NetworkPolicyPeer{
+				{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}},
+				{IPBlock: &networking.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.1.0/24"}}},
+			},
+		},
+	}
+	egress := []networking.NetworkPolicyEgressRule{
+		{
+			To: []networking.NetworkPolicyPeer{
+				{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}},
+			},
+		},
+	}
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default", Labels: map[string]string{"app": "server"}}}
+	policies := []networking.NetworkPolicy{testPolicy("web", ingressOnly, ingress, nil), testPolicy("db", egressOnly, nil, egress)}
+
+	puPolicy, err := generatePUPolicy(policies, pod, testNamespaces(), testPods, EnforcementModeEnforce, policy.NewTagStore(), policy.ExtendedMap{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	checkRulePolicyIDs(t, "receiver", puPolicy.ReceiverRules(), []string{"default/web#ingress[0]", "default/web#ingress[1].from[0]"})
+	checkACLPolicyIDs(t, "network", puPolicy.NetworkACLs(), []string{"default/web#ingress[0]", "default/web#ingress[1].from[1]"})
+	checkRulePolicyIDs(t, "transmitter", puPolicy.TransmitterRules(), []string{"default/db#egress[0].to[0]"})
+}
+
+func TestGeneratePUPolicyAllowAllPolicyID(t *testing.T) {
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default", Labels: map[string]string{"app": "server"}}}
+
+	puPolicy, err := generatePUPolicy(nil, pod, testNamespaces(), testPods, EnforcementModeEnforce, policy.NewTagStore(), policy.ExtendedMap{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	checkRulePolicyIDs(t, "receiver", puPolicy.ReceiverRules(), []string{AllowAllPolicyID})
+	checkACLPolicyIDs(t, "network", puPolicy.NetworkACLs(), []string{AllowAllPolicyID})
+}
+
+// checkRulePolicyIDs checks that every rule has one of the expected PolicyIDs, and that every expected PolicyID is used.
+func checkRulePolicyIDs(t *testing.T, name string, rules []policy.TagSelector, expected []string) {
+	ids := []string{}
+	for _, rule := range rules {
+		ids = append(ids, rule.Policy.PolicyID)
+	}
+	checkPolicyIDs(t, name, ids, expected)
+}
+
+// checkACLPolicyIDs checks that every ACL has one of the expected PolicyIDs, and that every expected PolicyID is used.
+func checkACLPolicyIDs(t *testing.T, name string, acls []policy.IPRule, expected []string) {
+	ids := []string{}
+	for _, acl := range acls {
+		ids = append(ids, acl.Policy.PolicyID)
+	}
+	checkPolicyIDs(t, name, ids, expected)
+}
+
+func checkPolicyIDs(t *testing.T, name string, ids []string, expected []string) {
+	used := map[string]bool{}
+	for _, id := range ids {
+		used[id] = true
+	}
+	for _, id := range expected {
+		if !used[id] {
+			t.Errorf("%s: no rule with PolicyID %s in %v", name, id, ids)
+		}
+		delete(used, id)
+	}
+	for id := range used {
+		t.Errorf("%s: unexpected PolicyID %s", name, id)
+	}
+}
+
+func TestPolicySelectsPeerNamespace(t *testing.T) {
+	payments := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}
+	teams := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "team", Operator: metav1.LabelSelectorOpIn, Values: []string{"payments", "billing"}},
+	}}
+	db := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+
+	tests := []struct {
+		name      string
+		ingress   []networking.NetworkPolicyIngressRule
+		egress    []networking.NetworkPolicyEgressRule
+		oldLabels map[string]string
+		newLabels map[string]string
+		// expectedOld and expectedNew are the expected results for the old and new labels.
+		expectedOld bool
+		expectedNew bool
+	}{
+		{
+			name:        "ingress namespace selector",
+			ingress:     []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{NamespaceSelector: payments}}}},
+			oldLabels:   map[string]string{"team": "ops"},
+			newLabels:   map[string]string{"team": "payments"},
+			expectedOld: false,
+			expectedNew: true,
+		},
+		{
+			name:        "egress namespace selector",
+			egress:      []networking.NetworkPolicyEgressRule{{To: []networking.NetworkPolicyPeer{{NamespaceSelector: payments}}}},
+			oldLabels:   map[string]string{"team": "payments"},
+			newLabels:   map[string]string{},
+			expectedOld: true,
+			expectedNew: false,
+		},
+		{
+			name:        "combined pod and namespace selector",
+			ingress:     []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{PodSelector: db, NamespaceSelector: payments}}}},
+			oldLabels:   map[string]string{"team": "payments"},
+			newLabels:   map[string]string{"team": "ops"},
+			expectedOld: true,
+			expectedNew: false,
+		},
+		{
+			name:        "pod selector only",
+			ingress:     []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{PodSelector: db}}}},
+			oldLabels:   map[string]string{"team": "payments"},
+			newLabels:   map[string]string{"team": "ops"},
+			expectedOld: false,
+			expectedNew: false,
+		},
+		{
+			name:        "label moved within the selector",
+			ingress:     []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{NamespaceSelector: teams}}}},
+			oldLabels:   map[string]string{"team": "payments"},
+			newLabels:   map[string]string{"team": "billing"},
+			expectedOld: true,
+			expectedNew: true,
+		},
+		{
+			name:        "empty namespace selector",
+			ingress:     []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}}},
+			oldLabels:   nil,
+			newLabels:   map[string]string{"team": "ops"},
+			expectedOld: true,
+			expectedNew: true,
+		},
+		{
+			name:        "no peers",
+			ingress:     allowAllIngress,
+			oldLabels:   map[string]string{"team": "payments"},
+			newLabels:   map[string]string{"team": "ops"},
+			expectedOld: false,
+			expectedNew: false,
+		},
+	}
+
+	for _, tt := range tests {
+		np := testPolicy("p", ingressEgress, tt.ingress, tt.egress)
+		selectsOld, err := policySelectsPeerNamespace(&np, tt.oldLabels)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+		selectsNew, err := policySelectsPeerNamespace(&np, tt.newLabels)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+		if selectsOld != tt.expectedOld || selectsNew != tt.expectedNew {
+			t.Errorf("%s: selects old and new labels %t %t, expected %t %t", tt.name, selectsOld, selectsNew, tt.expectedOld, tt.expectedNew)
+		}
+	}
+
+	invalid := testPolicy("p", ingressOnly, []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Unknown"}},
+	}}}}}, nil)
+	if _, err := policySelectsPeerNamespace(&invalid, nil); err == nil {
+		t.Errorf("expected an error for an invalid namespace selector")
+	}
+}
+
+func TestPolicySelectsPeerPod(t *testing.T) {
+	db := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	payments := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}
+
+	tests := []struct {
+		name         string
+		ingress      []networking.NetworkPolicyIngressRule
+		egress       []networking.NetworkPolicyEgressRule
+		podNamespace string
+		oldLabels    map[string]string
+		newLabels    map[string]string
+		// expectedOld and expectedNew are the expected results for the old and new labels.
+		expectedOld bool
+		expectedNew bool
+	}{
+		{
+			name:         "same namespace",
+			ingress:      []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{PodSelector: db}}}},
+			podNamespace: "default",
+			oldLabels:    map[string]string{"app": "db"},
+			newLabels:    map[string]string{"app": "db", "version": "2"},
+			expectedOld:  true,
+			expectedNew:  true,
+		},
+		{
+			name:         "pod selector of another namespace",
+			ingress:      []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{PodSelector: db}}}},
+			podNamespace: "payments",
+			oldLabels:    map[string]string{"app": "db"},
+			newLabels:    map[string]string{"app": "db"},
+			expectedOld:  false,
+			expectedNew:  false,
+		},
+		{
+			name:         "namespace selector",
+			egress:       []networking.NetworkPolicyEgressRule{{To: []networking.NetworkPolicyPeer{{PodSelector: db, NamespaceSelector: payments}}}},
+			podNamespace: "billing",
+			oldLabels:    map[string]string{"app": "db"},
+			newLabels:    map[string]string{"app": "db"},
+			expectedOld:  true,
+			expectedNew:  true,
+		},
+		{
+			name:         "namespace not selected",
+			egress:       []networking.NetworkPolicyEgressRule{{To: []networking.NetworkPolicyPeer{{PodSelector: db, NamespaceSelector: payments}}}},
+			podNamespace: "monitoring",
+			oldLabels:    map[string]string{"app": "db"},
+			newLabels:    map[string]string{"app": "db"},
+			expectedOld:  false,
+			expectedNew:  false,
+		},
+		{
+			name:         "label moved into the selector",
+			ingress:      []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{PodSelector: db}}}},
+			podNamespace: "default",
+			oldLabels:    map[string]string{"app": "web"},
+			newLabels:    map[string]string{"app": "db"},
+			expectedOld:  false,
+			expectedNew:  true,
+		},
+		{
+			name:         "label moved out of the selector",
+			ingress:      []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{PodSelector: db, NamespaceSelector: payments}}}},
+			podNamespace: "payments",
+			oldLabels:    map[string]string{"app": "db"},
+			newLabels:    map[string]string{},
+			expectedOld:  true,
+			expectedNew:  false,
+		},
+		{
+			name:         "namespace selector only",
+			ingress:      []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{{NamespaceSelector: payments}}}},
+			podNamespace: "payments",
+			oldLabels:    map[string]string{"app": "db"},
+			newLabels:    map[string]string{"app": "web"},
+			expectedOld:  false,
+			expectedNew:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		np := testPolicy("p", ingressEgress, tt.ingress, tt.egress)
+		selectsOld, err := policySelectsPeerPod(&np, tt.podNamespace, tt.oldLabels, testNamespaces())
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+		selectsNew, err := policySelectsPeerPod(&np, tt.podNamespace, tt.newLabels, testNamespaces())
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+		if selectsOld != tt.expectedOld || selectsNew != tt.expectedNew {
+			t.Errorf("%s: selects old and new labels %t %t, expected %t %t", tt.name, selectsOld, selectsNew, tt.expectedOld, tt.expectedNew)
+		}
+	}
+}
@@ -5,9 +5,15 @@ package resolver
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aporeto-inc/trireme-kubernetes/kubernetes"
+	"github.com/aporeto-inc/trireme-kubernetes/metrics"
 
 	"github.com/aporeto-inc/kubepox"
 	"go.aporeto.io/trireme-lib/common"
@@ -16,7 +22,9 @@ import (
 
 	api "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 
 	"go.uber.org/zap"
 )
@@ -30,12 +38,41 @@ type KubernetesPolicy struct {
 	triremeNetworks  []string
 	KubernetesClient *kubernetes.Client
 	cache            *cacheStruct
-	stopAll          chan struct{}
+
+	// stopAll is closed once to stop all the goroutines of the resolver, tracked by running.
+	stopAll  chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+	// ready is set to 1 once the initial state was synced.
+	ready int32
+
+	// queue keeps the pods for which the policy needs to be updated.
+	queue   workqueue.RateLimitingInterface
+	workers int
+	// heartbeat is the time in nanoseconds at which the workers last made progress, or were last seen idle.
+	heartbeat int64
+	// processing is the number of pods being updated by the workers.
+	processing int32
+
+	// enforcementMode is the EnforcementMode of the namespaces without EnforcementModeAnnotation.
+	enforcementMode EnforcementMode
+	// namespaceActivation selects the namespaces in which the NetworkPolicies are enforced.
+	namespaceActivation *NamespaceActivation
 }
 
+// cacheGCInterval is the interval at which the pods that are not scheduled on the node anymore
+// are removed from the cache.
+const cacheGCInterval = 5 * time.Minute
+
 // NewKubernetesPolicy creates a new policy engine for the Trireme package
-func NewKubernetesPolicy(ctx context.Context, controller controller.TriremeController, kubeconfig string, nodename string, triremeNetworks []string) (*KubernetesPolicy, error) {
-	client, err := kubernetes.NewClient(kubeconfig, nodename)
+// resync is the interval at which the Kubernetes informers replay their cache. 0 disables the resync.
+// workers is the number of pod policies that are updated concurrently.
+func NewKubernetesPolicy(ctx context.Context, controller controller.TriremeController, kubeconfig string, nodename string, triremeNetworks []string, resync time.Duration, workers int, enforcementMode EnforcementMode, namespaceActivation *NamespaceActivation) (*KubernetesPolicy, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("Invalid number of workers: %d", workers)
+	}
+
+	client, err := kubernetes.NewClient(kubeconfig, nodename, resync)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create KubernetesClient: %v ", err)
 	}
@@ -46,12 +83,14 @@ func NewKubernetesPolicy(ctx context.Context, controller controller.TriremeContr
 		triremeNetworks:  triremeNetworks,
 		KubernetesClient: client,
 		cache:            newCache(),
-	}, nil
-}
+		stopAll:          make(chan struct{}),
+		queue:            newPolicyQueue(),
+		workers:          workers,
+		heartbeat:        time.Now().UnixNano(),
+		enforcementMode:  enforcementMode,
 
-// isNamespaceKubeSystem returns true if the namespace is kube-system
-func isNamespaceKubeSystem(namespace string) bool {
-	return namespace == "kube-system"
+		namespaceActivation: namespaceActivation,
+	}, nil
 }
 
 // ResolvePolicy generates the Policy for the target PU.
@@ -90,9 +129,14 @@ func (k *KubernetesPolicy) HandlePUEvent(ctx context.Context, puID string, event
 			return fmt.Errorf("Error while creating the policy: %s", err)
 		}
 
+	case common.EventStop, common.EventDestroy:
+		// The pod is added back to the cache if its PU starts again.
+		if err := k.cache.deleteFromCacheByContextID(puID); err != nil {
+			zap.L().Debug("PU not found in cache", zap.String("contextID", puID), zap.Error(err))
+		}
+
 	case common.EventCreate:
 	case common.EventUpdate:
-	case common.EventDestroy:
 	case common.EventPause:
 	case common.EventUnpause:
 	}
@@ -105,117 +149,312 @@ func (k *KubernetesPolicy) resolvePodPolicy(runtime policy.RuntimeReader, kubern
 	// Query Kube API to get the Pod's label and IP.
 	zap.L().Info("Resolving policy for POD", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
 
+	start := time.Now()
+	defer func() {
+		metrics.PolicyResolutionDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	pod, err := k.KubernetesClient.Pod(kubernetesPod, kubernetesNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get Pod %s : %s", kubernetesPod, err)
+	}
+
+	allNamespaces, err := k.KubernetesClient.AllNamespaces()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get all namespaces: %s", err)
+	}
+
+	//ips := policy.ExtendedMap{policy.DefaultNamespace: pod.Status.PodIP}
+	ips := policy.ExtendedMap{}
+
+	// The identity follows the current labels of the pod, which might have changed since the runtime was created.
+	runtimeLabels := k.cache.runtimeLabelsByPodName(kubernetesPod, kubernetesNamespace, pod.GetLabels())
+	tags := podIdentityTags(runtime.Tags(), runtimeLabels, pod.GetLabels())
+
+	// The pods of the namespaces that are not activated are not policed.
+	if !k.namespaceActivation.isNamespaceActive(kubernetesNamespace, allNamespaces) {
+		zap.L().Debug("Namespace not activated. Allowing all", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
+		return allowAllPolicy(tags, ips, k.triremeNetworks), nil
+	}
+
 	nsNetworkPolicies, err := k.KubernetesClient.NetworkPolicies(kubernetesNamespace)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't generate current NetPolicies for the namespace %s ", kubernetesNamespace)
 	}
 
-	ingressPodRules, err := k.KubernetesClient.IngressPodRules(kubernetesPod, kubernetesNamespace, nsNetworkPolicies)
+	podPolicies, err := podNetworkPolicies(pod, nsNetworkPolicies)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get the NetworkPolicies for Pod %s : %s", kubernetesPod, err)
 	}
 
-	egressPodRules, err := k.KubernetesClient.EgressPodRules(kubernetesPod, kubernetesNamespace, nsNetworkPolicies)
+	mode := namespaceEnforcementMode(kubernetesNamespace, allNamespaces, k.enforcementMode)
+
+	puPolicy, err := generatePUPolicy(podPolicies, pod, allNamespaces, k.listPods, mode, tags, ips, k.triremeNetworks)
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't get the NetworkPolicies for Pod %s : %s", kubernetesPod, err)
+		return nil, err
+	}
+
+	return puPolicy, nil
+}
+
+// podIdentityTags returns the identity tags of a pod. The tags of the runtime that were generated
+// from runtimeLabels are replaced by the current labels of the pod.
+func podIdentityTags(runtimeTags *policy.TagStore, runtimeLabels map[string]string, podLabels map[string]string) *policy.TagStore {
+	tags := []string{}
+	for _, tag := range runtimeTags.GetSlice() {
+		key := strings.SplitN(tag, "=", 2)[0]
+		if _, ok := runtimeLabels[key]; ok {
+			continue
+		}
+		if _, ok := podLabels[key]; ok {
+			continue
+		}
+		tags = append(tags, tag)
 	}
 
-	allNamespaces, _ := k.KubernetesClient.AllNamespaces()
+	keys := []string{}
+	for key := range podLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	//ips := policy.ExtendedMap{policy.DefaultNamespace: pod.Status.PodIP}
-	ips := policy.ExtendedMap{}
+	identity := policy.NewTagStoreFromSlice(tags)
+	for _, key := range keys {
+		identity.AppendKeyValue(key, podLabels[key])
+	}
+	return identity
+}
 
-	puPolicy, err := generatePUPolicy(ingressPodRules, egressPodRules, kubernetesNamespace, allNamespaces, runtime.Tags(), ips, k.triremeNetworks)
+// listPods returns all the pods of a namespace. It is used to resolve named ports of remote pods.
+func (k *KubernetesPolicy) listPods(namespace string) ([]api.Pod, error) {
+	pods, err := k.KubernetesClient.Pods(namespace)
 	if err != nil {
 		return nil, err
 	}
-
-	return puPolicy, nil
+	return pods.Items, nil
 }
 
 // updatePodPolicy updates (and replace) the policy of the pod given in parameter.
-func (k *KubernetesPolicy) updatePodPolicy(pod *api.Pod) error {
-	podName := pod.GetName()
-	podNamespace := pod.GetNamespace()
+func (k *KubernetesPolicy) updatePodPolicy(podName string, podNamespace string) error {
 	zap.L().Info("Update pod Policy", zap.String("podNamespace", podNamespace), zap.String("podName", podName))
 
 	if k.controller == nil {
+		metrics.PolicyUpdateErrors.WithLabelValues(metrics.ReasonNoController).Inc()
 		return fmt.Errorf("PolicyUpdate failed: No PolicyUpdater registered")
 	}
 
 	// Finding back the ContextID for that specificPod.
 	contextID, err := k.cache.contextIDByPodName(podName, podNamespace)
 	if err != nil {
+		metrics.PolicyUpdateErrors.WithLabelValues(metrics.ReasonNotCached).Inc()
 		return fmt.Errorf("Error finding pod in cache for update: %s", err)
 	}
 
 	runtime, err := k.cache.runtimeByPodName(podName, podNamespace)
 	if err != nil {
+		metrics.PolicyUpdateErrors.WithLabelValues(metrics.ReasonNotCached).Inc()
 		return fmt.Errorf("Error finding pod in cache for update: %s", err)
 	}
 
 	// Regenerating a Full Policy and Tags.
 	containerPolicy, err := k.resolvePodPolicy(runtime, podName, podNamespace)
 	if err != nil {
+		metrics.PolicyUpdateErrors.WithLabelValues(metrics.ReasonResolution).Inc()
 		return fmt.Errorf("Couldn't generate a Pod Policy for pod update %s", err)
 	}
 
 	// TODO: Eventually find a way to not cast explicitely.
 	err = k.controller.UpdatePolicy(context.TODO(), contextID, containerPolicy, runtime.(*policy.PURuntime))
 	if err != nil {
+		metrics.PolicyUpdateErrors.WithLabelValues(metrics.ReasonEnforcement).Inc()
 		return fmt.Errorf("Error while updating the policy: %s", err)
 	}
 
+	metrics.PolicyUpdates.Inc()
 	return nil
 }
 
-// activateNamespace starts to watch the pods and networkpolicies in the parameter namespace.
+// activateNamespace starts to enforce the networkpolicies in the parameter namespace.
 func (k *KubernetesPolicy) activateNamespace(namespace *api.Namespace) error {
 	zap.L().Info("Activating namespace for NetworkPolicies", zap.String("namespace", namespace.GetName()))
+	k.cache.activateNamespace(namespace.GetName())
+	return nil
+}
+
+// deactivateNamespace stops to enforce the networkpolicies in the specified namespace.
+func (k *KubernetesPolicy) deactivateNamespace(namespace *api.Namespace) error {
+	zap.L().Info("Deactivating namespace for NetworkPolicies ", zap.String("namespace", namespace.GetName()))
+	k.cache.deactivateNamespace(namespace.GetName())
+	return nil
+}
 
-	npControllerStop := make(chan struct{})
-	npStore, npController := k.KubernetesClient.CreateNetworkPoliciesController(namespace.Name,
+// Run starts the KubernetesPolicer by watching for Namespace, NetworkPolicy and Pod Changes.
+// Run returns once the initial state of the Namespaces, NetworkPolicies and Pods is synced, or with an
+// error if ctx is done before. The KubernetesPolicer then runs until Stop is called or the context given
+// to NewKubernetesPolicy is done.
+func (k *KubernetesPolicy) Run(ctx context.Context) error {
+	k.KubernetesClient.AddNamespaceEventHandler(
+		k.addNamespace,
+		k.deleteNamespace,
+		k.updateNamespace)
+	k.KubernetesClient.AddNetworkPolicyEventHandler(
 		k.addNetworkPolicy,
 		k.deleteNetworkPolicy,
 		k.updateNetworkPolicy)
-	go npController.Run(npControllerStop)
-	zap.L().Debug("NetworkPolicy controller created", zap.String("namespace", namespace.GetName()))
+	k.KubernetesClient.AddPodEventHandler(
+		k.addPod,
+		k.deletePod,
+		k.updatePod)
+	k.KubernetesClient.AddLocalPodEventHandler(
+		k.addLocalPod,
+		k.deleteLocalPod,
+		k.updateLocalPod)
+	k.goRun(func() { k.KubernetesClient.Run(k.stopAll) })
+
+	k.beat()
+	k.runWorkers(k.workers, k.stopAll)
+	k.goRun(func() { k.beatWhileIdle(k.stopAll, heartbeatInterval) })
+
+	k.goRun(func() { k.garbageCollectCache(k.stopAll, cacheGCInterval) })
+
+	go func() {
+		select {
+		case <-k.globalContext.Done():
+			k.stop()
+		case <-k.stopAll:
+		}
+	}()
 
-	namespaceWatcher := NewNamespaceWatcher(namespace.Name, npStore, npController, npControllerStop)
-	k.cache.activateNamespaceWatcher(namespace.GetName(), namespaceWatcher)
-	zap.L().Debug("Finished namespace activation", zap.String("namespace", namespace.GetName()))
+	if !cache.WaitForCacheSync(ctx.Done(), k.KubernetesClient.HasSynced) {
+		return fmt.Errorf("Timeout while waiting for the Kubernetes caches to sync: %s", ctx.Err())
+	}
 
+	// The namespace events might not all be handled yet.
+	if err := k.activateNamespaces(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&k.ready, 1)
+	zap.L().Info("KubernetesPolicy synced")
 	return nil
 }
 
-// deactivateNamespace stops all the watching on the specified namespace.
-func (k *KubernetesPolicy) deactivateNamespace(namespace *api.Namespace) error {
-	zap.L().Info("Deactivating namespace for NetworkPolicies ", zap.String("namespace", namespace.GetName()))
-	k.cache.deactivateNamespaceWatcher(namespace.GetName())
+// Ready returns true once the initial state of the Namespaces, NetworkPolicies and Pods is synced.
+func (k *KubernetesPolicy) Ready() bool {
+	return atomic.LoadInt32(&k.ready) == 1
+}
+
+// CheckReady returns an error until the initial state of the Namespaces, NetworkPolicies and Pods is synced.
+func (k *KubernetesPolicy) CheckReady() error {
+	if !k.Ready() {
+		return fmt.Errorf("Kubernetes caches not synced")
+	}
 	return nil
 }
 
-// Run starts the KubernetesPolicer by watching for Namespace Changes.
-// Run is blocking. Use go
-func (k *KubernetesPolicy) Run(sync chan struct{}) {
-	k.stopAll = make(chan struct{})
-	_, nsController := k.KubernetesClient.CreateNamespaceController(
-		k.addNamespace,
-		k.deleteNamespace,
-		k.updateNamespace)
-	nsController.HasSynced()
-	go nsController.Run(k.stopAll)
+// CachedPods returns the number of pods with a PU in the cache.
+func (k *KubernetesPolicy) CachedPods() int {
+	return k.cache.podCount()
+}
+
+// ActiveNamespaces returns the number of namespaces in which the NetworkPolicies are enforced.
+func (k *KubernetesPolicy) ActiveNamespaces() int {
+	return k.cache.activeNamespaceCount()
+}
+
+// QueuedPods returns the number of pods waiting for a policy update.
+func (k *KubernetesPolicy) QueuedPods() int {
+	return k.queue.Len()
+}
 
-	if sync != nil {
-		go hasSynced(sync, nsController)
+// PodByContextID returns the pod of the PU. It is called for every flow and only reads the informer cache.
+func (k *KubernetesPolicy) PodByContextID(contextID string) (*api.Pod, error) {
+	podName, podNamespace, err := k.cache.podNameByContextID(contextID)
+	if err != nil {
+		return nil, err
 	}
+	return k.KubernetesClient.CachedPod(podName, podNamespace)
+}
+
+// PodByIP returns the pod having the given IP, wherever it is scheduled.
+func (k *KubernetesPolicy) PodByIP(ip string) (*api.Pod, error) {
+	return k.KubernetesClient.PodByIP(ip)
+}
+
+// PodServices returns the names of the Services selecting the pod.
+func (k *KubernetesPolicy) PodServices(pod *api.Pod) ([]string, error) {
+	return k.KubernetesClient.PodServices(pod)
 }
 
-// Stop Stops all the channels
-func (k *KubernetesPolicy) Stop() {
-	k.stopAll <- struct{}{}
-	for _, namespaceWatcher := range k.cache.namespaceActivation {
-		namespaceWatcher.stopWatchingNamespace()
+// PodWorkload returns the kind and name of the controller owning the pod.
+func (k *KubernetesPolicy) PodWorkload(pod *api.Pod) (string, error) {
+	return k.KubernetesClient.PodWorkload(pod)
+}
+
+// Alive returns an error once the KubernetesPolicy is stopped, or if its workers didn't update
+// any of the queued pods for heartbeatTimeout.
+func (k *KubernetesPolicy) Alive() error {
+	select {
+	case <-k.stopAll:
+		return fmt.Errorf("KubernetesPolicy is stopped")
+	default:
+	}
+
+	last := time.Unix(0, atomic.LoadInt64(&k.heartbeat))
+	if time.Since(last) > heartbeatTimeout {
+		return fmt.Errorf("No pod policy updated since %s while %d pods are queued", last.Format(time.RFC3339), k.queue.Len())
+	}
+	return nil
+}
+
+// activateNamespaces activates all the existing namespaces selected by the NamespaceActivation.
+func (k *KubernetesPolicy) activateNamespaces() error {
+	allNamespaces, err := k.KubernetesClient.AllNamespaces()
+	if err != nil {
+		return fmt.Errorf("Couldn't get all namespaces: %s", err)
+	}
+	for i := range allNamespaces.Items {
+		namespace := &allNamespaces.Items[i]
+		if k.namespaceActivation.isActive(namespace) {
+			k.cache.activateNamespace(namespace.GetName())
+		}
+	}
+	return nil
+}
+
+// goRun runs f in a goroutine waited for by Stop.
+func (k *KubernetesPolicy) goRun(f func()) {
+	k.running.Add(1)
+	go func() {
+		defer k.running.Done()
+		f()
+	}()
+}
+
+// stop signals all the goroutines of the resolver to stop. It can be called several times.
+func (k *KubernetesPolicy) stop() {
+	k.stopOnce.Do(func() {
+		close(k.stopAll)
+		k.queue.ShutDown()
+	})
+}
+
+// Stop stops the KubernetesPolicer and waits for all its goroutines to return.
+// An error is returned if they didn't return before ctx is done.
+func (k *KubernetesPolicy) Stop(ctx context.Context) error {
+	k.stop()
+
+	stopped := make(chan struct{})
+	go func() {
+		k.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Timeout while waiting for the KubernetesPolicy to stop: %s", ctx.Err())
 	}
 }
 
@@ -226,101 +465,311 @@ func (k *KubernetesPolicy) addNamespace(addedNS *api.Namespace) error {
 		return nil
 	}
 
-	// Every namespace is activated under GA networkpolicies
-	zap.L().Info("Namespace Added. Activating GA NetworkPolicies", zap.String("namespace", addedNS.GetName()))
-	return k.activateNamespace(addedNS)
+	if k.namespaceActivation.isActive(addedNS) {
+		zap.L().Info("Namespace Added. Activating GA NetworkPolicies", zap.String("namespace", addedNS.GetName()))
+		if err := k.activateNamespace(addedNS); err != nil {
+			return err
+		}
+		// The local pods of the namespace might have been resolved before the activation.
+		if err := k.updateNamespacePods(addedNS.GetName()); err != nil {
+			return err
+		}
+	} else {
+		zap.L().Info("Namespace Added. Not activated", zap.String("namespace", addedNS.GetName()))
+	}
+
+	// The new namespace might be matched by existing NamespaceSelectors.
+	return k.updateNamespaceSelectorPolicies(addedNS.GetLabels())
 }
 
 func (k *KubernetesPolicy) deleteNamespace(deletedNS *api.Namespace) error {
 	if k.cache.isNamespaceActive(deletedNS.GetName()) {
 		zap.L().Info("Namespace Deleted. Removing", zap.String("namespace", deletedNS.GetName()))
-		return k.deactivateNamespace(deletedNS)
+		if err := k.deactivateNamespace(deletedNS); err != nil {
+			return err
+		}
 	}
-	return nil
+
+	// The deleted namespace might have been matched by existing NamespaceSelectors.
+	return k.updateNamespaceSelectorPolicies(deletedNS.GetLabels())
 }
 
 func (k *KubernetesPolicy) updateNamespace(oldNS, updatedNS *api.Namespace) error {
-	// GA Policies. No changes.
-	return nil
+	wasActive := k.cache.isNamespaceActive(updatedNS.GetName())
+	isActive := k.namespaceActivation.isActive(updatedNS)
 
-}
+	switch {
+	case isActive && !wasActive:
+		zap.L().Info("Namespace Modified. Activating GA NetworkPolicies", zap.String("namespace", updatedNS.GetName()))
+		if err := k.activateNamespace(updatedNS); err != nil {
+			return err
+		}
+	case !isActive && wasActive:
+		zap.L().Info("Namespace Modified. Deactivating GA NetworkPolicies", zap.String("namespace", updatedNS.GetName()))
+		if err := k.deactivateNamespace(updatedNS); err != nil {
+			return err
+		}
+	}
 
-func (k *KubernetesPolicy) addNetworkPolicy(addedNP *networking.NetworkPolicy) error {
-	zap.L().Debug("NetworkPolicy Added.", zap.String("name", addedNP.GetName()), zap.String("namespace", addedNP.GetNamespace()))
+	if isActive != wasActive || oldNS.GetAnnotations()[EnforcementModeAnnotation] != updatedNS.GetAnnotations()[EnforcementModeAnnotation] {
+		if err := k.updateNamespacePods(updatedNS.GetName()); err != nil {
+			return err
+		}
+	}
 
-	// TODO: Filter on pods from localNode only.
-	allLocalPods, err := k.KubernetesClient.LocalPods(addedNP.Namespace)
+	if reflect.DeepEqual(oldNS.GetLabels(), updatedNS.GetLabels()) {
+		return nil
+	}
+
+	zap.L().Info("Namespace labels Modified", zap.String("namespace", updatedNS.GetName()))
+	return k.updateNamespaceSelectorPolicies(oldNS.GetLabels(), updatedNS.GetLabels())
+}
+
+// updateNamespacePods queues the re-resolution of all the local pods of a namespace.
+func (k *KubernetesPolicy) updateNamespacePods(namespace string) error {
+	localPods, err := k.KubernetesClient.LocalPods(namespace)
 	if err != nil {
 		return fmt.Errorf("Couldn't get all local pods: %s", err)
 	}
-	affectedPods, err := kubepox.ListPodsPerPolicy(addedNP, allLocalPods)
+	for _, pod := range localPods.Items {
+		k.enqueuePod(pod.GetName(), pod.GetNamespace())
+	}
+	return nil
+}
+
+// updateNamespaceSelectorPolicies re-resolves all the local pods selected by a NetworkPolicy with a
+// NamespaceSelector matching any of the sets of namespace labels given in parameter.
+func (k *KubernetesPolicy) updateNamespaceSelectorPolicies(namespaceLabels ...map[string]string) error {
+	activePolicies, err := k.activeNetworkPolicies()
 	if err != nil {
-		return fmt.Errorf("Couldn't get all pods for policy: %s , %s ", addedNP.GetName(), err)
+		return fmt.Errorf("Couldn't get all NetworkPolicies: %s", err)
 	}
-	//Reresolve all affected pods
-	for _, pod := range affectedPods.Items {
-		zap.L().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.Name), zap.String("namespace", pod.Namespace))
-		err := k.updatePodPolicy(&pod)
-		if err != nil {
-			return fmt.Errorf("UpdatePolicy failed: %s", err)
+
+	policies := []*networking.NetworkPolicy{}
+	for _, np := range activePolicies {
+		for _, nsLabels := range namespaceLabels {
+			selects, err := policySelectsPeerNamespace(np, nsLabels)
+			if err != nil {
+				return err
+			}
+			if selects {
+				policies = append(policies, np)
+				break
+			}
 		}
 	}
-	return nil
+	return k.updatePoliciesPods(policies)
+}
+
+func (k *KubernetesPolicy) addNetworkPolicy(addedNP *networking.NetworkPolicy) error {
+	metrics.NetworkPolicyEvents.WithLabelValues("add").Inc()
+	if !k.cache.isNamespaceActive(addedNP.GetNamespace()) {
+		return nil
+	}
+	zap.L().Debug("NetworkPolicy Added.", zap.String("name", addedNP.GetName()), zap.String("namespace", addedNP.GetNamespace()))
+	return k.updatePoliciesPods([]*networking.NetworkPolicy{addedNP})
 }
 
 func (k *KubernetesPolicy) deleteNetworkPolicy(deletedNP *networking.NetworkPolicy) error {
+	metrics.NetworkPolicyEvents.WithLabelValues("delete").Inc()
+	if !k.cache.isNamespaceActive(deletedNP.GetNamespace()) {
+		return nil
+	}
 	zap.L().Debug("NetworkPolicy Deleted.", zap.String("name", deletedNP.GetName()), zap.String("namespace", deletedNP.GetNamespace()))
+	return k.updatePoliciesPods([]*networking.NetworkPolicy{deletedNP})
+}
 
-	// TODO: Filter on pods from localNode only.
-	allLocalPods, err := k.KubernetesClient.LocalPods(deletedNP.Namespace)
-	if err != nil {
-		return fmt.Errorf("Couldn't get all local pods: %s", err)
+func (k *KubernetesPolicy) updateNetworkPolicy(oldNP, updatedNP *networking.NetworkPolicy) error {
+	metrics.NetworkPolicyEvents.WithLabelValues("update").Inc()
+	// Periodic resyncs replay unchanged NetworkPolicies.
+	if !k.cache.isNamespaceActive(updatedNP.GetNamespace()) || oldNP.GetResourceVersion() == updatedNP.GetResourceVersion() {
+		return nil
+	}
+	zap.L().Debug("NetworkPolicy Modified", zap.String("name", updatedNP.GetName()), zap.String("namespace", updatedNP.GetNamespace()))
+
+	// The pods that are not selected anymore need to be updated as well.
+	return k.updatePoliciesPods([]*networking.NetworkPolicy{oldNP, updatedNP})
+}
+
+func (k *KubernetesPolicy) addPod(addedPod *api.Pod) error {
+	if !hasNamedContainerPorts(addedPod) {
+		return nil
 	}
-	affectedPods, err := kubepox.ListPodsPerPolicy(deletedNP, allLocalPods)
+
+	zap.L().Debug("Pod with named ports Added.", zap.String("name", addedPod.GetName()), zap.String("namespace", addedPod.GetNamespace()))
+	return k.updateNamedPortPolicies()
+}
+
+func (k *KubernetesPolicy) deletePod(deletedPod *api.Pod) error {
+	if !hasNamedContainerPorts(deletedPod) {
+		return nil
+	}
+
+	zap.L().Debug("Pod with named ports Deleted.", zap.String("name", deletedPod.GetName()), zap.String("namespace", deletedPod.GetNamespace()))
+	return k.updateNamedPortPolicies()
+}
+
+func (k *KubernetesPolicy) updatePod(oldPod, updatedPod *api.Pod) error {
+	// Container ports are immutable. Only a change of labels can change the pods selected by a peer.
+	if !hasNamedContainerPorts(updatedPod) || reflect.DeepEqual(oldPod.GetLabels(), updatedPod.GetLabels()) {
+		return nil
+	}
+
+	zap.L().Debug("Pod with named ports Modified", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
+	return k.updateNamedPortPolicies()
+}
+
+// updateNamedPortPolicies re-resolves all the local pods selected by a NetworkPolicy that has
+// named ports in its egress rules. Those named ports are resolved against remote pods that might have changed.
+func (k *KubernetesPolicy) updateNamedPortPolicies() error {
+	activePolicies, err := k.activeNetworkPolicies()
 	if err != nil {
-		return fmt.Errorf("Couldn't get all pods for policy: %s , %s ", deletedNP.GetName(), err)
+		return fmt.Errorf("Couldn't get all NetworkPolicies: %s", err)
 	}
-	//Reresolve all affected pods
-	for _, pod := range affectedPods.Items {
-		zap.L().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.GetName()), zap.String("namespace", pod.GetNamespace()))
-		err := k.updatePodPolicy(&pod)
-		if err != nil {
-			return fmt.Errorf("UpdatePolicy failed: %s", err)
+
+	policies := []*networking.NetworkPolicy{}
+	for _, np := range activePolicies {
+		if hasNamedEgressPorts(np) {
+			policies = append(policies, np)
 		}
 	}
+	return k.updatePoliciesPods(policies)
+}
+
+func (k *KubernetesPolicy) addLocalPod(addedPod *api.Pod) error {
+	// The policy of a new pod is resolved when its PU starts.
 	return nil
 }
 
-func (k *KubernetesPolicy) updateNetworkPolicy(oldNP, updatedNP *networking.NetworkPolicy) error {
-	zap.L().Debug("NetworkPolicy Modified", zap.String("name", updatedNP.GetName()), zap.String("namespace", updatedNP.GetNamespace()))
+func (k *KubernetesPolicy) deleteLocalPod(deletedPod *api.Pod) error {
+	// The PU of the pod might already be destroyed.
+	if err := k.cache.deleteFromCacheByPodName(deletedPod.GetName(), deletedPod.GetNamespace()); err != nil {
+		zap.L().Debug("Deleted pod not found in cache", zap.String("name", deletedPod.GetName()), zap.String("namespace", deletedPod.GetNamespace()))
+	}
+	return nil
+}
+
+func (k *KubernetesPolicy) updateLocalPod(oldPod, updatedPod *api.Pod) error {
+	if reflect.DeepEqual(oldPod.GetLabels(), updatedPod.GetLabels()) {
+		return nil
+	}
+	zap.L().Debug("Local pod labels Modified", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
 
-	// TODO: Filter on pods from localNode only.
-	allLocalPods, err := k.KubernetesClient.LocalPods(updatedNP.Namespace)
+	// The identity and the policies selecting the pod itself might have changed.
+	k.enqueuePod(updatedPod.GetName(), updatedPod.GetNamespace())
+
+	// The rules of the local pods with a peer selecting the old or new labels might have changed.
+	allNamespaces, err := k.KubernetesClient.AllNamespaces()
 	if err != nil {
-		return fmt.Errorf("Couldn't get all local pods: %s", err)
+		return fmt.Errorf("Couldn't get all namespaces: %s", err)
 	}
-	affectedPods, err := kubepox.ListPodsPerPolicy(updatedNP, allLocalPods)
+
+	activePolicies, err := k.activeNetworkPolicies()
 	if err != nil {
-		return fmt.Errorf("Couldn't get all pods for policy: %s , %s ", updatedNP.GetName(), err)
+		return fmt.Errorf("Couldn't get all NetworkPolicies: %s", err)
 	}
-	//Reresolve all affected pods
-	for _, pod := range affectedPods.Items {
-		zap.L().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.GetName()), zap.String("name", pod.GetNamespace()))
-		err := k.updatePodPolicy(&pod)
+
+	policies := []*networking.NetworkPolicy{}
+	for _, np := range activePolicies {
+		selectsOld, err := policySelectsPeerPod(np, oldPod.GetNamespace(), oldPod.GetLabels(), allNamespaces)
+		if err != nil {
+			return err
+		}
+		selectsUpdated, err := policySelectsPeerPod(np, updatedPod.GetNamespace(), updatedPod.GetLabels(), allNamespaces)
 		if err != nil {
-			return fmt.Errorf("UpdatePolicy failed: %s", err)
+			return err
+		}
+		if selectsOld || selectsUpdated {
+			policies = append(policies, np)
+		}
+	}
+
+	return k.updatePoliciesPods(policies)
+}
+
+// activeNetworkPolicies returns all the NetworkPolicies of the activated namespaces.
+func (k *KubernetesPolicy) activeNetworkPolicies() ([]*networking.NetworkPolicy, error) {
+	allPolicies, err := k.KubernetesClient.NetworkPolicies(metav1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	policies := []*networking.NetworkPolicy{}
+	for i := range allPolicies.Items {
+		if k.cache.isNamespaceActive(allPolicies.Items[i].GetNamespace()) {
+			policies = append(policies, &allPolicies.Items[i])
+		}
+	}
+	return policies, nil
+}
+
+// updatePoliciesPods queues the re-resolution of all the local pods selected by the NetworkPolicies.
+func (k *KubernetesPolicy) updatePoliciesPods(policies []*networking.NetworkPolicy) error {
+	for _, np := range policies {
+		allLocalPods, err := k.KubernetesClient.LocalPods(np.Namespace)
+		if err != nil {
+			return fmt.Errorf("Couldn't get all local pods: %s", err)
+		}
+		affectedPods, err := kubepox.ListPodsPerPolicy(np, allLocalPods)
+		if err != nil {
+			return fmt.Errorf("Couldn't get all pods for policy: %s , %s ", np.GetName(), err)
+		}
+		//Reresolve all affected pods
+		for _, pod := range affectedPods.Items {
+			k.enqueuePod(pod.GetName(), pod.GetNamespace())
 		}
 	}
 	return nil
 }
 
-// hasSynced sends an event on the Sync chan when the attachedController finished syncing.
-func hasSynced(sync chan struct{}, controller cache.Controller) {
-	for true {
-		if controller.HasSynced() {
-			sync <- struct{}{}
+// garbageCollectCache periodically removes from the cache the pods that are not scheduled on the node anymore.
+// Those are the pods for which the deletion or the PU destroy event was missed.
+// Pods added to the cache during the last interval are kept as the pod lister might not know about them yet.
+func (k *KubernetesPolicy) garbageCollectCache(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
 			return
+		case now := <-ticker.C:
+			if !k.KubernetesClient.HasSynced() {
+				continue
+			}
+			removed := k.cache.garbageCollect(func(kubeIdentifier string) bool {
+				podNamespace, podName, err := cache.SplitMetaNamespaceKey(kubeIdentifier)
+				if err != nil {
+					return true
+				}
+				scheduled, err := k.KubernetesClient.IsLocalPod(podName, podNamespace)
+				// Keep the pod if the lister can't tell.
+				return scheduled || err != nil
+			}, now.Add(-interval))
+			for _, kubeIdentifier := range removed {
+				zap.L().Info("Removed pod not scheduled on the node anymore from cache", zap.String("pod", kubeIdentifier))
+			}
+		}
+	}
+}
+
+// hasNamedContainerPorts returns true if any container of the pod declares a named port.
+func hasNamedContainerPorts(pod *api.Pod) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasNamedEgressPorts returns true if any egress rule of the NetworkPolicy uses a named port.
+func hasNamedEgressPorts(np *networking.NetworkPolicy) bool {
+	for _, rule := range np.Spec.Egress {
+		if hasNamedPorts(rule.Ports) {
+			return true
 		}
-		<-time.After(100 * time.Millisecond)
 	}
+	return false
 }
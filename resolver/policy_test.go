@@ -0,0 +1,321 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/kubernetes"
+
+	"go.aporeto.io/trireme-lib/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPodIdentityTags(t *testing.T) {
+	runtimeTags := policy.NewTagStoreFromSlice([]string{
+		"k8s:name=server",
+		"k8s:namespace=default",
+		"app=server",
+		"tier=backend",
+		"version=1",
+	})
+	runtimeLabels := map[string]string{"app": "server", "tier": "backend", "version": "1"}
+	podLabels := map[string]string{"app": "server", "version": "2", "team": "payments"}
+
+	identity := podIdentityTags(runtimeTags, runtimeLabels, podLabels)
+
+	expected := []string{
+		"k8s:name=server",
+		"k8s:namespace=default",
+		"app=server",
+		"team=payments",
+		"version=2",
+	}
+	if !reflect.DeepEqual(identity.GetSlice(), expected) {
+		t.Errorf("identity is %v, expected %v", identity.GetSlice(), expected)
+	}
+}
+
+func TestStop(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache(), queue: newPolicyQueue(), stopAll: make(chan struct{})}
+	k.runWorkers(2, k.stopAll)
+	k.goRun(func() { <-k.stopAll })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.Stop(ctx); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// Stopping again is a no-op.
+	if err := k.Stop(ctx); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache(), queue: newPolicyQueue(), stopAll: make(chan struct{})}
+	k.beat()
+	if err := k.Alive(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// A queued pod that is not updated for heartbeatTimeout means that the workers are stuck.
+	stale := time.Now().Add(-2 * heartbeatTimeout).UnixNano()
+	atomic.StoreInt64(&k.heartbeat, stale)
+	k.enqueuePod("web-0", "default")
+	k.goRun(func() { k.beatWhileIdle(k.stopAll, time.Millisecond) })
+	time.Sleep(20 * time.Millisecond)
+	if err := k.Alive(); err == nil {
+		t.Errorf("Expected an error while the queued pod is not updated")
+	}
+
+	// Updating the pod sets the heartbeat.
+	k.processNextPod()
+	if err := k.Alive(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// The heartbeat is set while the workers are idle.
+	atomic.StoreInt64(&k.heartbeat, stale)
+	deadline := time.Now().Add(5 * time.Second)
+	for k.Alive() != nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := k.Alive(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.Stop(ctx); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := k.Alive(); err == nil {
+		t.Errorf("Expected an error once stopped")
+	}
+}
+
+func TestRun(t *testing.T) {
+	k := newTestKubernetesPolicy(t, fake.NewSimpleClientset(testNamespace("default", nil)))
+	if k.Ready() || k.CheckReady() == nil {
+		t.Errorf("Expected the KubernetesPolicy not to be ready before Run")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.Run(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !k.Ready() {
+		t.Errorf("Expected the KubernetesPolicy to be ready after Run")
+	}
+	if err := k.CheckReady(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if !k.cache.isNamespaceActive("default") {
+		t.Errorf("Expected the default namespace to be activated")
+	}
+
+	if err := k.Stop(ctx); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestRunSyncTimeout(t *testing.T) {
+	// The pods are never listed, so the caches never sync.
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("list failed")
+	})
+	k := newTestKubernetesPolicy(t, clientset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := k.Run(ctx); err == nil {
+		t.Errorf("Expected a timeout error")
+	}
+	if k.Ready() || k.CheckReady() == nil {
+		t.Errorf("Expected the KubernetesPolicy not to be ready")
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopCancel()
+	if err := k.Stop(stopCtx); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache(), queue: newPolicyQueue(), stopAll: make(chan struct{})}
+	blocked := make(chan struct{})
+	defer close(blocked)
+	k.goRun(func() { <-blocked })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := k.Stop(ctx); err == nil {
+		t.Errorf("Expected a timeout error")
+	}
+}
+
+// testKubernetesPolicy returns a KubernetesPolicy for node-1 with the Kubernetes objects in its synced caches.
+// The caller must close stopAll.
+func testKubernetesPolicy(t *testing.T, objects ...runtime.Object) *KubernetesPolicy {
+	k := newTestKubernetesPolicy(t, fake.NewSimpleClientset(objects...))
+	go k.KubernetesClient.Run(k.stopAll)
+	if !cache.WaitForCacheSync(k.stopAll, k.KubernetesClient.HasSynced) {
+		t.Fatalf("Kubernetes caches not synced")
+	}
+	return k
+}
+
+// newTestKubernetesPolicy returns a KubernetesPolicy for node-1 using the clientset, without running it.
+func newTestKubernetesPolicy(t *testing.T, clientset *fake.Clientset) *KubernetesPolicy {
+	client, err := kubernetes.NewClientFromInterface(clientset, "node-1", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	namespaceActivation, err := NewNamespaceActivation("", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return &KubernetesPolicy{
+		globalContext:       context.Background(),
+		KubernetesClient:    client,
+		cache:               newCache(),
+		queue:               newPolicyQueue(),
+		stopAll:             make(chan struct{}),
+		namespaceActivation: namespaceActivation,
+	}
+}
+
+// queuedPods drains the queue and returns the sorted keys of the queued pods.
+func queuedPods(k *KubernetesPolicy) []string {
+	pods := []string{}
+	for k.queue.Len() > 0 {
+		key, _ := k.queue.Get()
+		k.queue.Done(key)
+		k.queue.Forget(key)
+		pods = append(pods, key.(string))
+	}
+	sort.Strings(pods)
+	return pods
+}
+
+func testNamespace(name string, labels map[string]string) *api.Namespace {
+	return &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func testNodePod(name string, namespace string, node string, labels map[string]string) *api.Pod {
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       api.PodSpec{NodeName: node},
+	}
+}
+
+// testPeerPolicy returns a NetworkPolicy of the default namespace selecting the pods labeled app
+// and allowing the ingress traffic from the peer.
+func testPeerPolicy(name string, app string, peer networking.NetworkPolicyPeer) *networking.NetworkPolicy {
+	return &networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+			Ingress:     []networking.NetworkPolicyIngressRule{{From: []networking.NetworkPolicyPeer{peer}}},
+			PolicyTypes: ingressOnly,
+		},
+	}
+}
+
+func namespacePeer(team string) networking.NetworkPolicyPeer {
+	return networking.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": team}}}
+}
+
+func TestUpdateNamespaceQueuesSelectedPods(t *testing.T) {
+	oldNS := testNamespace("payments", map[string]string{"team": "payments"})
+	updatedNS := testNamespace("payments", map[string]string{"team": "ops"})
+
+	k := testKubernetesPolicy(t,
+		testNamespace("default", nil),
+		updatedNS,
+		testPeerPolicy("from-payments", "server", namespacePeer("payments")),
+		testPeerPolicy("from-ops", "client", namespacePeer("ops")),
+		testPeerPolicy("from-billing", "db", namespacePeer("billing")),
+		testNodePod("server-0", "default", "node-1", map[string]string{"app": "server"}),
+		testNodePod("server-1", "default", "node-2", map[string]string{"app": "server"}),
+		testNodePod("client-0", "default", "node-1", map[string]string{"app": "client"}),
+		testNodePod("db-0", "default", "node-1", map[string]string{"app": "db"}),
+	)
+	defer close(k.stopAll)
+	defer k.queue.ShutDown()
+	k.cache.activateNamespace("default")
+	k.cache.activateNamespace("payments")
+
+	// Only the local pods selected by a policy matching the old or the new labels are queued.
+	if err := k.updateNamespace(oldNS, updatedNS); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{"default/client-0", "default/server-0"}
+	if pods := queuedPods(k); !reflect.DeepEqual(pods, expected) {
+		t.Errorf("Queued pods %v, expected %v", pods, expected)
+	}
+
+	// Nothing is queued when the labels did not change.
+	if err := k.updateNamespace(updatedNS, updatedNS); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if pods := queuedPods(k); len(pods) != 0 {
+		t.Errorf("Queued pods %v, expected none", pods)
+	}
+}
+
+func TestUpdateLocalPodQueuesSelectingPods(t *testing.T) {
+	oldPod := testNodePod("web-0", "default", "node-1", map[string]string{"role": "cache"})
+	updatedPod := testNodePod("web-0", "default", "node-1", map[string]string{"role": "store"})
+	rolePeer := func(role string) networking.NetworkPolicyPeer {
+		return networking.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": role}}}
+	}
+
+	k := testKubernetesPolicy(t,
+		testNamespace("default", nil),
+		testPeerPolicy("from-cache", "server", rolePeer("cache")),
+		testPeerPolicy("from-store", "client", rolePeer("store")),
+		testPeerPolicy("from-queue", "db", rolePeer("queue")),
+		updatedPod,
+		testNodePod("server-0", "default", "node-1", map[string]string{"app": "server"}),
+		testNodePod("server-1", "default", "node-2", map[string]string{"app": "server"}),
+		testNodePod("client-0", "default", "node-1", map[string]string{"app": "client"}),
+		testNodePod("db-0", "default", "node-1", map[string]string{"app": "db"}),
+	)
+	defer close(k.stopAll)
+	defer k.queue.ShutDown()
+	k.cache.activateNamespace("default")
+
+	// The pod itself and the local pods selected by a policy with a peer matching its old or new labels are queued.
+	if err := k.updateLocalPod(oldPod, updatedPod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{"default/client-0", "default/server-0", "default/web-0"}
+	if pods := queuedPods(k); !reflect.DeepEqual(pods, expected) {
+		t.Errorf("Queued pods %v, expected %v", pods, expected)
+	}
+
+	// Nothing is queued when the labels did not change.
+	if err := k.updateLocalPod(updatedPod, updatedPod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if pods := queuedPods(k); len(pods) != 0 {
+		t.Errorf("Queued pods %v, expected none", pods)
+	}
+}
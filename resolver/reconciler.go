@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"go.uber.org/zap"
+)
+
+// maxPolicyRetries is the number of times the policy update of a pod is retried before it is dropped.
+const maxPolicyRetries = 15
+
+// heartbeatInterval is the interval at which the heartbeat is set while the workers are idle.
+const heartbeatInterval = 10 * time.Second
+
+// heartbeatTimeout is the time without heartbeat after which the workers are considered stuck.
+const heartbeatTimeout = 5 * time.Minute
+
+// newPolicyQueue creates the queue of the pods for which the policy needs to be updated.
+// Failed updates are retried with an exponential backoff.
+func newPolicyQueue() workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "trireme-pod-policies")
+}
+
+// enqueuePod queues the update of the policy of the pod. A pod queued several times before
+// being processed is updated only once.
+func (k *KubernetesPolicy) enqueuePod(podName string, podNamespace string) {
+	k.queue.Add(kubePodIdentifier(podName, podNamespace))
+}
+
+// runWorkers processes the queued pods with the given number of workers until stop is closed.
+func (k *KubernetesPolicy) runWorkers(workers int, stop <-chan struct{}) {
+	for i := 0; i < workers; i++ {
+		k.goRun(func() { wait.Until(k.runWorker, time.Second, stop) })
+	}
+}
+
+func (k *KubernetesPolicy) runWorker() {
+	for k.processNextPod() {
+	}
+}
+
+// processNextPod updates the policy of the next queued pod. It returns false once the queue is shut down.
+func (k *KubernetesPolicy) processNextPod() bool {
+	key, quit := k.queue.Get()
+	if quit {
+		return false
+	}
+	defer k.queue.Done(key)
+
+	atomic.AddInt32(&k.processing, 1)
+	defer func() {
+		atomic.AddInt32(&k.processing, -1)
+		k.beat()
+	}()
+
+	err := k.reconcilePod(key.(string))
+	if err == nil {
+		k.queue.Forget(key)
+		return true
+	}
+
+	if k.queue.NumRequeues(key) < maxPolicyRetries {
+		zap.L().Debug("Policy update failed. Retrying", zap.String("pod", key.(string)), zap.Error(err))
+		k.queue.AddRateLimited(key)
+		return true
+	}
+
+	zap.L().Error("Policy update failed. Dropping pod", zap.String("pod", key.(string)), zap.Error(err))
+	k.queue.Forget(key)
+	return true
+}
+
+// beat sets the heartbeat of the workers to now.
+func (k *KubernetesPolicy) beat() {
+	atomic.StoreInt64(&k.heartbeat, time.Now().UnixNano())
+}
+
+// beatWhileIdle sets the heartbeat every interval while no pod is queued or being updated, until stop is closed.
+// Otherwise the heartbeat is only set by the workers, once they are done with a pod.
+func (k *KubernetesPolicy) beatWhileIdle(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if k.queue.Len() == 0 && atomic.LoadInt32(&k.processing) == 0 {
+				k.beat()
+			}
+		}
+	}
+}
+
+// reconcilePod updates the policy of the pod identified by key.
+func (k *KubernetesPolicy) reconcilePod(key string) error {
+	podNamespace, podName, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("Invalid pod key %s: %s", key, err)
+	}
+
+	// Pods without PU yet are resolved when their PU starts.
+	if _, err := k.cache.contextIDByPodName(podName, podNamespace); err != nil {
+		return nil
+	}
+
+	zap.L().Debug("Updating pod policy", zap.String("name", podName), zap.String("namespace", podNamespace))
+	return k.updatePodPolicy(podName, podNamespace)
+}
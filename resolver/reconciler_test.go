@@ -0,0 +1,40 @@
+package resolver
+
+import "testing"
+
+func TestEnqueuePodDeduplicates(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache(), queue: newPolicyQueue()}
+	defer k.queue.ShutDown()
+
+	k.enqueuePod("web-0", "default")
+	k.enqueuePod("web-0", "default")
+	k.enqueuePod("web-1", "default")
+
+	if k.queue.Len() != 2 {
+		t.Errorf("Expected 2 queued pods, got %d", k.queue.Len())
+	}
+}
+
+func TestProcessNextPod(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache(), queue: newPolicyQueue()}
+	defer k.queue.ShutDown()
+
+	// Pods without PU are skipped.
+	k.enqueuePod("web-0", "default")
+	if !k.processNextPod() {
+		t.Fatalf("Expected the queue to be running")
+	}
+	if requeues := k.queue.NumRequeues(kubePodIdentifier("web-0", "default")); requeues != 0 {
+		t.Errorf("Expected pod without PU not to be retried, got %d requeues", requeues)
+	}
+
+	// Failed updates are retried. There is no controller to update the policy with.
+	k.cache.addPodToCache("abc", nil, "web-1", "default")
+	k.enqueuePod("web-1", "default")
+	if !k.processNextPod() {
+		t.Fatalf("Expected the queue to be running")
+	}
+	if requeues := k.queue.NumRequeues(kubePodIdentifier("web-1", "default")); requeues != 1 {
+		t.Errorf("Expected failed update to be retried once, got %d requeues", requeues)
+	}
+}
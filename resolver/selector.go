@@ -2,6 +2,10 @@ package resolver
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
@@ -12,6 +16,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func clauseEquals(requirement labels.Requirement) []policy.KeyValueOperator {
@@ -74,235 +79,698 @@ func clauseDoesNotExist(requirement labels.Requirement) []policy.KeyValueOperato
 	}
 }
 
-// portSelector generates all the clauses for the ports
-func portSelector(ports []networking.NetworkPolicyPort) []policy.KeyValueOperator {
-	// If Port is not defined, then no need for specific traffic matching.
-	if ports == nil {
-		return []policy.KeyValueOperator{}
+// namedPortResolver returns the numeric ports that a named port refers to for a specific protocol.
+type namedPortResolver func(name string, protocol api.Protocol) []string
+
+// peerPortResolver returns the namedPortResolver to use for the pods selected
+// by podSelector in the given namespaces.
+type peerPortResolver func(podSelector *metav1.LabelSelector, namespaces []string) (namedPortResolver, error)
+
+// podLister returns all the pods of a namespace.
+type podLister func(namespace string) ([]api.Pod, error)
+
+// containerPortResolver resolves named ports against the container ports of the pods given in parameter.
+func containerPortResolver(pods []api.Pod) namedPortResolver {
+	return func(name string, protocol api.Protocol) []string {
+		matched := map[int32]bool{}
+		for _, pod := range pods {
+			for _, container := range pod.Spec.Containers {
+				for _, containerPort := range container.Ports {
+					containerProtocol := containerPort.Protocol
+					if containerProtocol == "" {
+						containerProtocol = api.ProtocolTCP
+					}
+					if containerPort.Name == name && containerProtocol == protocol {
+						matched[containerPort.ContainerPort] = true
+					}
+				}
+			}
+		}
+
+		numericPorts := []int{}
+		for port := range matched {
+			numericPorts = append(numericPorts, int(port))
+		}
+		sort.Ints(numericPorts)
+
+		ports := []string{}
+		for _, port := range numericPorts {
+			ports = append(ports, strconv.Itoa(port))
+		}
+		return ports
 	}
-	// If Port is defined but no ports are defined into it, then No traffic is matched at all
-	if len(ports) == 0 {
-		return nil
+}
+
+// selectedPodsPortResolver returns a peerPortResolver that resolves named ports against
+// the container ports of the pods selected by the peer.
+func selectedPodsPortResolver(pods podLister) peerPortResolver {
+	return func(podSelector *metav1.LabelSelector, namespaces []string) (namedPortResolver, error) {
+		selector, err := metav1.LabelSelectorAsSelector(podSelector)
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+		}
+
+		selectedPods := []api.Pod{}
+		for _, namespace := range namespaces {
+			namespacePods, err := pods(namespace)
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't list pods for namespace %s: %s", namespace, err)
+			}
+			for _, pod := range namespacePods {
+				if selector.Matches(labels.Set(pod.GetLabels())) {
+					selectedPods = append(selectedPods, pod)
+				}
+			}
+		}
+
+		return containerPortResolver(selectedPods), nil
 	}
+}
 
-	portList := []string{}
+// targetPodPortResolver returns a peerPortResolver that always resolves named ports against the pod given in parameter.
+func targetPodPortResolver(pod *api.Pod) peerPortResolver {
+	return func(podSelector *metav1.LabelSelector, namespaces []string) (namedPortResolver, error) {
+		return containerPortResolver([]api.Pod{*pod}), nil
+	}
+}
+
+// hasNamedPorts returns true if any of the ports is a named port.
+func hasNamedPorts(ports []networking.NetworkPolicyPort) bool {
 	for _, port := range ports {
-		portList = append(portList, port.Port.String())
+		if port.Port != nil && port.Port.Type == intstr.String {
+			return true
+		}
 	}
-	kvo := policy.KeyValueOperator{
-		Key:      "$sys:port",
-		Operator: policy.Equal,
-		Value:    portList,
+	return false
+}
+
+// resolvePort returns the numeric ports of a NetworkPolicyPort with a defined Port.
+// Named ports are resolved through namedPorts and don't return anything if they can't be resolved.
+func resolvePort(port networking.NetworkPolicyPort, namedPorts namedPortResolver) []string {
+	if port.Port.Type == intstr.Int {
+		return []string{port.Port.String()}
 	}
-	return []policy.KeyValueOperator{kvo}
 
+	if namedPorts == nil {
+		zap.L().Debug("Named port can't be resolved for this peer", zap.String("port", port.Port.StrVal))
+		return nil
+	}
+
+	protocol := api.ProtocolTCP
+	if port.Protocol != nil {
+		protocol = *port.Protocol
+	}
+	return namedPorts(port.Port.StrVal, protocol)
 }
 
-func namespaceSelector(namespace string) []policy.KeyValueOperator {
-	kvo := policy.KeyValueOperator{
-		Key:      UpstreamNamespaceIdentifier,
-		Operator: policy.Equal,
-		Value:    []string{namespace},
+// portProtocol returns the protocol of a NetworkPolicyPort. TCP is the default as per Kubernetes spec.
+func portProtocol(port networking.NetworkPolicyPort) (api.Protocol, error) {
+	if port.Protocol == nil {
+		return api.ProtocolTCP, nil
+	}
+
+	switch *port.Protocol {
+	case api.ProtocolTCP, api.ProtocolUDP, api.ProtocolSCTP:
+		return *port.Protocol, nil
+	default:
+		return "", fmt.Errorf("Unknown ProtocolType %s", *port.Protocol)
 	}
-	return []policy.KeyValueOperator{kvo}
 }
 
-// podRules generates all the rules for the whole pod.
-func podIngressRules(rule *networking.NetworkPolicyIngressRule, namespace string) ([]policy.TagSelector, error) {
+// protocolPorts are the ports matched for one protocol.
+type protocolPorts struct {
+	protocol api.Protocol
+	// ports is nil if all the ports of the protocol are matched.
+	ports []string
+}
 
-	receiverRules := []policy.TagSelector{}
-	for _, peer := range rule.From {
+// portsPerProtocol groups the ports per protocol, keeping the order in which the protocols are defined.
+// Protocols for which none of the named ports could be resolved are not returned. The port ranges
+// defined with EndPort are returned as "port:endPort".
+func portsPerProtocol(ports []networking.NetworkPolicyPort, namedPorts namedPortResolver) ([]protocolPorts, error) {
+	groups := []protocolPorts{}
+	groupIndex := map[api.Protocol]int{}
+	allPorts := map[api.Protocol]bool{}
 
-		// Individual From. Each From is ORed.
-		peerSelector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+	for _, port := range ports {
+		protocol, err := portProtocol(port)
 		if err != nil {
-			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			return nil, err
 		}
-		peerRequirements, _ := peerSelector.Requirements()
 
-		// Initialize the completeClause with the port matching
-		completeClause := []policy.KeyValueOperator{}
-		completeClause = append(completeClause, portSelector(rule.Ports)...)
-
-		// Also add the Pod Namespace as a requirement.
-		completeClause = append(completeClause, namespaceSelector(namespace)...)
-
-		// Go over each specific requirement and add it as a clause.
-		for _, requirement := range peerRequirements {
-			// Each requirement is ANDed
-			switch requirement.Operator() {
-			case selection.Equals:
-				requirementClause := clauseEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotEquals:
-				requirementClause := clauseNotEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.In:
-				requirementClause := clauseIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotIn:
-				requirementClause := clauseNotIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.Exists:
-				requirementClause := clauseExists(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.DoesNotExist:
-				requirementClause := clauseDoesNotExist(requirement)
-				completeClause = append(completeClause, requirementClause...)
+		i, ok := groupIndex[protocol]
+		if !ok {
+			i = len(groups)
+			groupIndex[protocol] = i
+			groups = append(groups, protocolPorts{protocol: protocol, ports: []string{}})
+		}
+
+		// A port entry without Port matches all the ports of the protocol.
+		if port.Port == nil {
+			allPorts[protocol] = true
+			continue
+		}
+		// A port entry with EndPort matches the range of ports from Port to EndPort.
+		if port.EndPort != nil {
+			if port.Port.Type == intstr.String {
+				return nil, fmt.Errorf("Invalid EndPort %d: not allowed with the named port %s", *port.EndPort, port.Port.StrVal)
 			}
+			if *port.EndPort < port.Port.IntVal {
+				return nil, fmt.Errorf("Invalid EndPort %d: lower than the port %d", *port.EndPort, port.Port.IntVal)
+			}
+			groups[i].ports = append(groups[i].ports, fmt.Sprintf("%d:%d", port.Port.IntVal, *port.EndPort))
+			continue
+		}
+		groups[i].ports = append(groups[i].ports, resolvePort(port, namedPorts)...)
+	}
 
+	result := []protocolPorts{}
+	for _, group := range groups {
+		if allPorts[group.protocol] {
+			group.ports = nil
+		} else if len(group.ports) == 0 {
+			continue
 		}
-		selector := policy.TagSelector{
+		result = append(result, group)
+	}
+
+	return result, nil
+}
+
+// portSelector generates the clauses matching the ports. The flows between pods are matched on
+// their PortIdentifier tag only: Trireme doesn't tag them with their protocol, so the ports of all
+// the protocols are matched. The protocols are enforced by the ACLs of the IPBlock peers only.
+// If no ports are defined, or a protocol is allowed on all its ports, a single empty clause matching
+// all the traffic is returned. If no clauses are returned, then no traffic is matched at all.
+func portSelector(ports []networking.NetworkPolicyPort, namedPorts namedPortResolver) ([][]policy.KeyValueOperator, error) {
+	// If Port is not defined, then no need for specific traffic matching.
+	if len(ports) == 0 {
+		return [][]policy.KeyValueOperator{{}}, nil
+	}
+
+	groups, err := portsPerProtocol(ports, namedPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	portList := []string{}
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if group.ports == nil {
+			return [][]policy.KeyValueOperator{{}}, nil
+		}
+		for _, port := range group.ports {
+			if !seen[port] {
+				seen[port] = true
+				portList = append(portList, port)
+			}
+		}
+	}
+	if len(portList) == 0 {
+		return [][]policy.KeyValueOperator{}, nil
+	}
+
+	return [][]policy.KeyValueOperator{{
+		policy.KeyValueOperator{
+			Key:      PortIdentifier,
+			Operator: policy.Equal,
+			Value:    portList,
+		},
+	}}, nil
+}
+
+// selectorRules generates one accepting TagSelector per port clause, each of them ANDed with the peer clause.
+func selectorRules(portClauses [][]policy.KeyValueOperator, peerClause []policy.KeyValueOperator) []policy.TagSelector {
+	rules := []policy.TagSelector{}
+	for _, portClause := range portClauses {
+		completeClause := []policy.KeyValueOperator{}
+		completeClause = append(completeClause, portClause...)
+		completeClause = append(completeClause, peerClause...)
+
+		rules = append(rules, policy.TagSelector{
 			Clause: completeClause,
 			Policy: &policy.FlowPolicy{
 				Action: policy.Accept,
 			},
+		})
+	}
+	return rules
+}
+
+func namespaceSelector(namespaces ...string) []policy.KeyValueOperator {
+	kvo := policy.KeyValueOperator{
+		Key:      UpstreamNamespaceIdentifier,
+		Operator: policy.Equal,
+		Value:    namespaces,
+	}
+	return []policy.KeyValueOperator{kvo}
+}
+
+// labelSelectorClause generates the clauses matching a Kubernetes LabelSelector.
+// Each requirement of the selector is ANDed.
+func labelSelectorClause(labelSelector *metav1.LabelSelector) ([]policy.KeyValueOperator, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+	}
+	requirements, _ := selector.Requirements()
+
+	completeClause := []policy.KeyValueOperator{}
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals:
+			completeClause = append(completeClause, clauseEquals(requirement)...)
+		case selection.NotEquals:
+			completeClause = append(completeClause, clauseNotEquals(requirement)...)
+		case selection.In:
+			completeClause = append(completeClause, clauseIn(requirement)...)
+		case selection.NotIn:
+			completeClause = append(completeClause, clauseNotIn(requirement)...)
+		case selection.Exists:
+			completeClause = append(completeClause, clauseExists(requirement)...)
+		case selection.DoesNotExist:
+			completeClause = append(completeClause, clauseDoesNotExist(requirement)...)
 		}
-		receiverRules = append(receiverRules, selector)
 	}
 
-	return receiverRules, nil
+	return completeClause, nil
 }
 
-// podRules generates all the rules for the whole pod.
-func podEgressRules(rule *networking.NetworkPolicyEgressRule, namespace string) ([]policy.TagSelector, error) {
+// matchingNamespaces returns the sorted names of all the namespaces matched by the LabelSelector.
+func matchingNamespaces(labelSelector *metav1.LabelSelector, allNamespaces *api.NamespaceList) ([]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+	}
+
+	namespaces := []string{}
+	if allNamespaces == nil {
+		return namespaces, nil
+	}
+	for _, namespace := range allNamespaces.Items {
+		if selector.Matches(labels.Set(namespace.GetLabels())) {
+			namespaces = append(namespaces, namespace.GetName())
+		}
+	}
+	sort.Strings(namespaces)
 
-	TransmitterRules := []policy.TagSelector{}
-	for _, peer := range rule.To {
+	return namespaces, nil
+}
 
-		// Individual From. Each From is ORed.
-		peerSelector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+// peerNamespaces returns the namespaces in which the pods of a peer are selected.
+// Without NamespaceSelector, the peer only matches pods in the namespace of the policy.
+// With a NamespaceSelector, the peer matches the selected pods in all the selected namespaces (AND semantics).
+func peerNamespaces(peer networking.NetworkPolicyPeer, namespace string, allNamespaces *api.NamespaceList) ([]string, error) {
+	if peer.NamespaceSelector == nil {
+		return []string{namespace}, nil
+	}
+	return matchingNamespaces(peer.NamespaceSelector, allNamespaces)
+}
+
+// podPeerRules generates the rules for a peer with a PodSelector.
+func podPeerRules(peer networking.NetworkPolicyPeer, ports []networking.NetworkPolicyPort, namespace string, allNamespaces *api.NamespaceList, peerPorts peerPortResolver) ([]policy.TagSelector, error) {
+	// IPBlock and NamespaceSelector only peers are handled separately.
+	if peer.PodSelector == nil {
+		return nil, nil
+	}
+
+	namespaces, err := peerNamespaces(peer, namespace, allNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	// No namespace matched, so no pod can match either.
+	if len(namespaces) == 0 {
+		return nil, nil
+	}
+
+	// Named ports are only resolved if needed as it might require listing pods.
+	var namedPorts namedPortResolver
+	if hasNamedPorts(ports) {
+		namedPorts, err = peerPorts(peer.PodSelector, namespaces)
 		if err != nil {
-			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			return nil, err
 		}
-		peerRequirements, _ := peerSelector.Requirements()
+	}
 
-		// Initialize the completeClause with the port matching
-		completeClause := []policy.KeyValueOperator{}
-		completeClause = append(completeClause, portSelector(rule.Ports)...)
-
-		// Also add the Pod Namespace as a requirement.
-		completeClause = append(completeClause, namespaceSelector(namespace)...)
-
-		// Go over each specific requirement and add it as a clause.
-		for _, requirement := range peerRequirements {
-			// Each requirement is ANDed
-			switch requirement.Operator() {
-			case selection.Equals:
-				requirementClause := clauseEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotEquals:
-				requirementClause := clauseNotEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.In:
-				requirementClause := clauseIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotIn:
-				requirementClause := clauseNotIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.Exists:
-				requirementClause := clauseExists(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.DoesNotExist:
-				requirementClause := clauseDoesNotExist(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			}
+	portClauses, err := portSelector(ports, namedPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	podClause, err := labelSelectorClause(peer.PodSelector)
+	if err != nil {
+		return nil, err
+	}
 
+	// The Pod Namespaces are also a requirement.
+	peerClause := namespaceSelector(namespaces...)
+	peerClause = append(peerClause, podClause...)
+
+	return selectorRules(portClauses, peerClause), nil
+}
+
+// podIngressRules generates all the rules for the PodSelector peers of an IngressRule.
+// Named ports are resolved against the container ports of the pod receiving the traffic.
+func podIngressRules(rule *networking.NetworkPolicyIngressRule, pod *api.Pod, allNamespaces *api.NamespaceList) ([]policy.TagSelector, error) {
+
+	receiverRules := []policy.TagSelector{}
+	for _, peer := range rule.From {
+		// Individual From. Each From is ORed.
+		peerRules, err := podPeerRules(peer, rule.Ports, pod.GetNamespace(), allNamespaces, targetPodPortResolver(pod))
+		if err != nil {
+			return nil, err
 		}
-		selector := policy.TagSelector{
-			Clause: completeClause,
-			Policy: &policy.FlowPolicy{
-				Action: policy.Accept,
-			},
+		receiverRules = append(receiverRules, peerRules...)
+	}
+
+	return receiverRules, nil
+}
+
+// podEgressRules generates all the rules for the PodSelector peers of an EgressRule.
+// Named ports are resolved against the container ports of the pods selected by each peer.
+func podEgressRules(rule *networking.NetworkPolicyEgressRule, namespace string, allNamespaces *api.NamespaceList, pods podLister) ([]policy.TagSelector, error) {
+
+	transmitterRules := []policy.TagSelector{}
+	for _, peer := range rule.To {
+		// Individual To. Each To is ORed.
+		peerRules, err := podPeerRules(peer, rule.Ports, namespace, allNamespaces, selectedPodsPortResolver(pods))
+		if err != nil {
+			return nil, err
 		}
-		TransmitterRules = append(TransmitterRules, selector)
+		transmitterRules = append(transmitterRules, peerRules...)
 	}
 
-	return TransmitterRules, nil
+	return transmitterRules, nil
+}
+
+// allPodsPortRules generates the rules matching the ports for all the pods of all the namespaces.
+func allPodsPortRules(ports []networking.NetworkPolicyPort, namedPorts namedPortResolver) ([]policy.TagSelector, error) {
+	portClauses, err := portSelector(ports, namedPorts)
+	if err != nil {
+		return nil, err
+	}
+	return selectorRules(portClauses, namespaceSelector("*")), nil
 }
 
 // aclIngressRules generate the IPRules used as ACLs outside of Trireme cluster.
-func aclIngressRules(rule networking.NetworkPolicyIngressRule) ([]policy.IPRule, error) {
+// Named ports are resolved through namedPorts.
+func aclIngressRules(rule networking.NetworkPolicyIngressRule, namedPorts namedPortResolver) ([]policy.IPRule, error) {
+	return aclRules("0.0.0.0/0", rule.Ports, namedPorts, policy.Accept)
+}
+
+// aclEgressRules generate the IPRules used as ACLs outside of Trireme cluster.
+// Named ports can't be resolved for destinations outside of the cluster and are ignored.
+func aclEgressRules(rule networking.NetworkPolicyEgressRule) ([]policy.IPRule, error) {
+	return aclRules("0.0.0.0/0", rule.Ports, nil, policy.Accept)
+}
+
+// aclIPBlockIngressRules generates the IPRules for all the IPBlock peers of an IngressRule.
+func aclIPBlockIngressRules(rule networking.NetworkPolicyIngressRule, namedPorts namedPortResolver) ([]policy.IPRule, error) {
 	aclPolicy := []policy.IPRule{}
-	if rule.Ports == nil {
-		return nil, fmt.Errorf("Ports entry is nil")
+	for _, peer := range rule.From {
+		if peer.IPBlock == nil {
+			continue
+		}
+		ipBlockRules, err := ipBlockACLs(peer.IPBlock, rule.Ports, namedPorts)
+		if err != nil {
+			return nil, err
+		}
+		aclPolicy = append(aclPolicy, ipBlockRules...)
 	}
 
-	for _, portEntry := range rule.Ports {
-		var proto string
-		if *portEntry.Protocol == api.ProtocolUDP {
-			proto = "UDP"
-		} else if *portEntry.Protocol == api.ProtocolTCP {
-			proto = "TCP"
-		} else {
-			return nil, fmt.Errorf("Unknown ProtocolType")
-		}
+	return aclPolicy, nil
+}
 
-		ipRuleTCP := policy.IPRule{
-			Address:  "0.0.0.0/0",
-			Port:     portEntry.Port.String(),
-			Protocol: proto,
-			Policy: &policy.FlowPolicy{
-				Action: policy.Accept,
-			},
+// aclIPBlockEgressRules generates the IPRules for all the IPBlock peers of an EgressRule.
+// Named ports can't be resolved for destinations outside of the cluster and are ignored.
+func aclIPBlockEgressRules(rule networking.NetworkPolicyEgressRule) ([]policy.IPRule, error) {
+	aclPolicy := []policy.IPRule{}
+	for _, peer := range rule.To {
+		if peer.IPBlock == nil {
+			continue
 		}
-
-		ipRuleUDP := policy.IPRule{
-			Address:  "0.0.0.0/0",
-			Port:     portEntry.Port.String(),
-			Protocol: proto,
-			Policy: &policy.FlowPolicy{
-				Action: policy.Accept,
-			},
+		ipBlockRules, err := ipBlockACLs(peer.IPBlock, rule.Ports, nil)
+		if err != nil {
+			return nil, err
 		}
-		aclPolicy = append(aclPolicy, ipRuleTCP, ipRuleUDP)
+		aclPolicy = append(aclPolicy, ipBlockRules...)
 	}
 
 	return aclPolicy, nil
 }
 
-// aclIngressRules generate the IPRules used as ACLs outside of Trireme cluster.
-func aclEgressRules(rule networking.NetworkPolicyEgressRule) ([]policy.IPRule, error) {
+// ipBlockACLs generates the IPRules for one IPBlock. The except ranges are rendered as Reject rules
+// placed before the Accept rules of the CIDR, as the Reject rules take precedence in Trireme.
+// As the ACLs of all the rules are merged, the Reject rules are narrowed by exceptACLs so that they
+// don't block the traffic allowed by the other peers and rules.
+func ipBlockACLs(ipBlock *networking.IPBlock, ports []networking.NetworkPolicyPort, namedPorts namedPortResolver) ([]policy.IPRule, error) {
+	_, cidr, err := net.ParseCIDR(ipBlock.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid IPBlock CIDR %s: %s", ipBlock.CIDR, err)
+	}
+
 	aclPolicy := []policy.IPRule{}
-	if rule.Ports == nil {
-		return nil, fmt.Errorf("Ports entry is nil")
+	for _, except := range ipBlock.Except {
+		_, exceptNet, err := net.ParseCIDR(except)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid IPBlock except %s: %s", except, err)
+		}
+		if !cidrContains(cidr, exceptNet) {
+			return nil, fmt.Errorf("IPBlock except %s is not contained in %s", except, ipBlock.CIDR)
+		}
+
+		exceptRules, err := aclRules(exceptNet.String(), ports, namedPorts, policy.Reject)
+		if err != nil {
+			return nil, err
+		}
+		aclPolicy = append(aclPolicy, exceptRules...)
+	}
+
+	cidrRules, err := aclRules(cidr.String(), ports, namedPorts, policy.Accept)
+	if err != nil {
+		return nil, err
 	}
+	return append(aclPolicy, cidrRules...), nil
+}
 
-	for _, portEntry := range rule.Ports {
-		var proto string
-		if *portEntry.Protocol == api.ProtocolUDP {
-			proto = "UDP"
-		} else if *portEntry.Protocol == api.ProtocolTCP {
-			proto = "TCP"
-		} else {
-			return nil, fmt.Errorf("Unknown ProtocolType")
+// cidrContains returns true if inner is a subnet of outer, of the same address family.
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// excludeCIDR returns the prefixes covering r without except. When except is inside r, r is split
+// bit by bit down to except, keeping at each step the half that doesn't contain except.
+func excludeCIDR(r, except *net.IPNet) []*net.IPNet {
+	if cidrContains(except, r) {
+		return nil
+	}
+	if !cidrContains(r, except) {
+		return []*net.IPNet{r}
+	}
+
+	rOnes, bits := r.Mask.Size()
+	exceptOnes, _ := except.Mask.Size()
+	prefixes := []*net.IPNet{}
+	for ones := rOnes + 1; ones <= exceptOnes; ones++ {
+		mask := net.CIDRMask(ones, bits)
+		sibling := except.IP.Mask(mask)
+		sibling[(ones-1)/8] ^= 0x80 >> uint((ones-1)%8)
+		prefixes = append(prefixes, &net.IPNet{IP: sibling, Mask: mask})
+	}
+	return prefixes
+}
+
+// aclRegion is the traffic matched by an IPRule: a network, a protocol and a range of ports.
+type aclRegion struct {
+	network  *net.IPNet
+	protocol string
+	from     int
+	to       int
+}
+
+// newACLRegion returns the region matched by an IPRule.
+func newACLRegion(acl policy.IPRule) (aclRegion, error) {
+	_, network, err := net.ParseCIDR(acl.Address)
+	if err != nil {
+		return aclRegion{}, fmt.Errorf("Invalid ACL address %s: %s", acl.Address, err)
+	}
+
+	bounds := strings.SplitN(acl.Port, ":", 2)
+	from, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return aclRegion{}, fmt.Errorf("Invalid ACL port %s: %s", acl.Port, err)
+	}
+	to := from
+	if len(bounds) == 2 {
+		if to, err = strconv.Atoi(bounds[1]); err != nil {
+			return aclRegion{}, fmt.Errorf("Invalid ACL port %s: %s", acl.Port, err)
 		}
+	}
 
-		ipRuleTCP := policy.IPRule{
-			Address:  "0.0.0.0/0",
-			Port:     portEntry.Port.String(),
-			Protocol: proto,
-			Policy: &policy.FlowPolicy{
-				Action: policy.Accept,
-			},
+	return aclRegion{network: network, protocol: acl.Protocol, from: from, to: to}, nil
+}
+
+// port returns the ports of the region in the format of the IPRules.
+func (r aclRegion) port() string {
+	if r.from == r.to {
+		return strconv.Itoa(r.from)
+	}
+	return fmt.Sprintf("%d:%d", r.from, r.to)
+}
+
+// subtract returns the regions covering r without s.
+func (r aclRegion) subtract(s aclRegion) []aclRegion {
+	if r.protocol != s.protocol || r.to < s.from || s.to < r.from {
+		return []aclRegion{r}
+	}
+	if !cidrContains(r.network, s.network) && !cidrContains(s.network, r.network) {
+		return []aclRegion{r}
+	}
+
+	// The parts of the network of r outside of s keep all the ports of r.
+	regions := []aclRegion{}
+	for _, network := range excludeCIDR(r.network, s.network) {
+		regions = append(regions, aclRegion{network: network, protocol: r.protocol, from: r.from, to: r.to})
+	}
+
+	// The network shared with s keeps the ports of r outside of s.
+	shared := r.network
+	if cidrContains(r.network, s.network) {
+		shared = s.network
+	}
+	if r.from < s.from {
+		regions = append(regions, aclRegion{network: shared, protocol: r.protocol, from: r.from, to: s.from - 1})
+	}
+	if s.to < r.to {
+		regions = append(regions, aclRegion{network: shared, protocol: r.protocol, from: s.to + 1, to: r.to})
+	}
+	return regions
+}
+
+// subtractRegions returns the regions covering all the regions without the excluded ones.
+func subtractRegions(regions []aclRegion, excluded []aclRegion) []aclRegion {
+	for _, s := range excluded {
+		remaining := []aclRegion{}
+		for _, r := range regions {
+			remaining = append(remaining, r.subtract(s)...)
 		}
+		regions = remaining
+	}
+	return regions
+}
 
-		ipRuleUDP := policy.IPRule{
-			Address:  "0.0.0.0/0",
-			Port:     portEntry.Port.String(),
-			Protocol: proto,
-			Policy: &policy.FlowPolicy{
-				Action: policy.Accept,
-			},
+// exceptACLs narrows the Reject rules of the IPBlock excepts so that they only reject the traffic
+// that no other peer or rule accepts: as they are evaluated before all the Accept rules, they would
+// otherwise override them. The traffic accepted by a peer is the one of its Accept rules without its
+// own except ranges. The Reject rules are returned first, followed by the Accept rules.
+func exceptACLs(acls []policy.IPRule) ([]policy.IPRule, error) {
+	policyIDs := []string{}
+	accepted := map[string][]aclRegion{}
+	rejected := map[string][]aclRegion{}
+	rejects := []policy.IPRule{}
+	rejectRegions := []aclRegion{}
+	accepts := []policy.IPRule{}
+
+	for _, acl := range acls {
+		region, err := newACLRegion(acl)
+		if err != nil {
+			return nil, err
+		}
+		policyID := acl.Policy.PolicyID
+		if _, ok := accepted[policyID]; !ok {
+			policyIDs = append(policyIDs, policyID)
+			accepted[policyID] = []aclRegion{}
+		}
+		if acl.Policy.Action == policy.Reject {
+			rejected[policyID] = append(rejected[policyID], region)
+			rejects = append(rejects, acl)
+			rejectRegions = append(rejectRegions, region)
+			continue
+		}
+		accepted[policyID] = append(accepted[policyID], region)
+		accepts = append(accepts, acl)
+	}
+
+	if len(rejects) == 0 {
+		return acls, nil
+	}
+
+	for _, policyID := range policyIDs {
+		accepted[policyID] = subtractRegions(accepted[policyID], rejected[policyID])
+	}
+
+	aclPolicy := []policy.IPRule{}
+	for i, reject := range rejects {
+		regions := []aclRegion{rejectRegions[i]}
+		for _, policyID := range policyIDs {
+			if policyID != reject.Policy.PolicyID {
+				regions = subtractRegions(regions, accepted[policyID])
+			}
+		}
+
+		for _, region := range regions {
+			acl := ipRule(region.network.String(), region.port(), region.protocol, policy.Reject)
+			acl.Policy.PolicyID = reject.Policy.PolicyID
+			aclPolicy = append(aclPolicy, acl)
+		}
+	}
+
+	return append(aclPolicy, accepts...), nil
+}
+
+// aclRules generates the IPRules for an address and a set of ports, one rule per port and protocol.
+// Without ports, all the TCP, UDP and SCTP ports are matched, as the tag selector rules do. A port entry without Port matches all
+// the ports of its protocol. Named ports are resolved through namedPorts. Duplicate rules are removed.
+func aclRules(address string, ports []networking.NetworkPolicyPort, namedPorts namedPortResolver, action policy.ActionType) ([]policy.IPRule, error) {
+	if len(ports) == 0 {
+		return []policy.IPRule{
+			ipRule(address, "0:65535", string(api.ProtocolTCP), action),
+			ipRule(address, "0:65535", string(api.ProtocolUDP), action),
+			ipRule(address, "0:65535", string(api.ProtocolSCTP), action),
+		}, nil
+	}
+
+	groups, err := portsPerProtocol(ports, namedPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	aclPolicy := []policy.IPRule{}
+	for _, group := range groups {
+		if group.ports == nil {
+			aclPolicy = append(aclPolicy, ipRule(address, "0:65535", string(group.protocol), action))
+			continue
+		}
+
+		added := map[string]bool{}
+		for _, port := range group.ports {
+			if added[port] {
+				continue
+			}
+			added[port] = true
+			aclPolicy = append(aclPolicy, ipRule(address, port, string(group.protocol), action))
 		}
-		aclPolicy = append(aclPolicy, ipRuleTCP, ipRuleUDP)
 	}
 
 	return aclPolicy, nil
 }
 
+func ipRule(address string, port string, protocol string, action policy.ActionType) policy.IPRule {
+	return policy.IPRule{
+		Address:  address,
+		Port:     port,
+		Protocol: protocol,
+		Policy: &policy.FlowPolicy{
+			Action: action,
+		},
+	}
+}
+
 // generateIngressRulesList generates the Trireme receiver rules and ACLs based on a set of Kubernetes IngressRules that apply to a pod.
-func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngressRule, podNamespace string, allNamespaces *api.NamespaceList) ([]policy.TagSelector, []policy.IPRule, error) {
+// The rules are generated peer by peer so that each of them carries the PolicyID of its peer.
+func generateIngressRulesList(ingressKubeRules *[]policyIngressRule, pod *api.Pod, allNamespaces *api.NamespaceList) ([]policy.TagSelector, []policy.IPRule, error) {
 
 	// with rules==nil, it means allow all.
 	if ingressKubeRules == nil {
@@ -314,6 +782,8 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 		return rulesAndACLsDenyAll()
 	}
 
+	podNamespace := pod.GetNamespace()
+	namedPorts := containerPortResolver([]api.Pod{*pod})
 	receiverRules := []policy.TagSelector{}
 	ipRules := []policy.IPRule{}
 
@@ -322,16 +792,18 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 
 		// From is not set, Only using the Port information.
 		if rule.From == nil {
-			if rule.Ports == nil {
-				// Allow All Ingress  ?
-				// Ports also not set: Allow All!
+			aclSelectorRules, err := aclIngressRules(rule.NetworkPolicyIngressRule, namedPorts)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod ACLRules: %s", err)
 			}
+			ipRules = append(ipRules, aclsWithPolicyID(aclSelectorRules, rule.id)...)
 
-			aclSelectorRules, err := aclIngressRules(rule)
+			// All the pods are matched as well.
+			allPodsRules, err := allPodsPortRules(rule.Ports, namedPorts)
 			if err != nil {
-				return nil, nil, fmt.Errorf("Error creating pod ACLRules: %s", err)
+				return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
 			}
-			ipRules = append(ipRules, aclSelectorRules...)
+			receiverRules = append(receiverRules, rulesWithPolicyID(allPodsRules, rule.id)...)
 			continue
 		}
 
@@ -340,26 +812,45 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 			continue
 		}
 
-		// Phase1: populate the clauses related to each individual rules.
-		podSelectorRules, err := podIngressRules(&rule, podNamespace)
-		if err != nil {
-			return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
-		}
-		receiverRules = append(receiverRules, podSelectorRules...)
+		for j, peer := range rule.From {
+			peerID := fmt.Sprintf("%s.from[%d]", rule.id, j)
+			peerRule := rule.NetworkPolicyIngressRule
+			peerRule.From = []networking.NetworkPolicyPeer{peer}
 
-		// Phase2: populate the clauses related to the namespace rules. (namepace selector...)
-		namespaceSelectorRules, err := namespaceIngressRules(&rule, podNamespace, allNamespaces)
-		if err != nil {
-			return nil, nil, fmt.Errorf("Error creating pod namespaceRule: %s", err)
+			// Phase0: populate the ACLs related to the IPBlock peers.
+			ipBlockRules, err := aclIPBlockIngressRules(peerRule, namedPorts)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod IPBlock ACLRules: %s", err)
+			}
+			ipRules = append(ipRules, aclsWithPolicyID(ipBlockRules, peerID)...)
+
+			// Phase1: populate the clauses related to each individual rules.
+			podSelectorRules, err := podIngressRules(&peerRule, pod, allNamespaces)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
+			}
+			receiverRules = append(receiverRules, rulesWithPolicyID(podSelectorRules, peerID)...)
+
+			// Phase2: populate the clauses related to the namespace rules. (namepace selector...)
+			namespaceSelectorRules, err := namespaceIngressRules(&peerRule, podNamespace, allNamespaces, targetPodPortResolver(pod))
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod namespaceRule: %s", err)
+			}
+			receiverRules = append(receiverRules, rulesWithPolicyID(namespaceSelectorRules, peerID)...)
 		}
+	}
 
-		receiverRules = append(receiverRules, namespaceSelectorRules...)
+	ipRules, err := exceptACLs(ipRules)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating pod IPBlock ACLRules: %s", err)
 	}
 
 	return receiverRules, ipRules, nil
 }
 
-func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRule, podNamespace string, allNamespaces *api.NamespaceList) ([]policy.TagSelector, []policy.IPRule, error) {
+// generateEgressRulesList generates the Trireme transmitter rules and ACLs based on a set of Kubernetes EgressRules that apply to a pod.
+// The rules are generated peer by peer so that each of them carries the PolicyID of its peer.
+func generateEgressRulesList(egressKubeRules *[]policyEgressRule, podNamespace string, allNamespaces *api.NamespaceList, pods podLister) ([]policy.TagSelector, []policy.IPRule, error) {
 	// with rules==nil, it means allow all.
 	if egressKubeRules == nil {
 		return rulesAndACLsAllowAll()
@@ -378,57 +869,102 @@ func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRu
 
 		// To is not set, Only using the Port information.
 		if rule.To == nil {
-			if rule.Ports == nil {
-				// Ports also not set: Allow All!
-				// Allow All Ingress  ?
-			}
-
-			aclSelectorRules, err := aclEgressRules(rule)
+			aclSelectorRules, err := aclEgressRules(rule.NetworkPolicyEgressRule)
 			if err != nil {
 				return nil, nil, fmt.Errorf("Error creating pod ACLRules: %s", err)
 			}
-			ipRules = append(ipRules, aclSelectorRules...)
+			ipRules = append(ipRules, aclsWithPolicyID(aclSelectorRules, rule.id)...)
+
+			// All the pods are matched as well. Named ports are resolved against all of them.
+			var namedPorts namedPortResolver
+			if hasNamedPorts(rule.Ports) {
+				namedPorts, err = selectedPodsPortResolver(pods)(&metav1.LabelSelector{}, []string{metav1.NamespaceAll})
+				if err != nil {
+					return nil, nil, fmt.Errorf("Error resolving named ports: %s", err)
+				}
+			}
+			allPodsRules, err := allPodsPortRules(rule.Ports, namedPorts)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
+			}
+			transmitterRules = append(transmitterRules, rulesWithPolicyID(allPodsRules, rule.id)...)
 			continue
 		}
 
 		// Not matching any traffic. Go to next rule
-		if len(rule.To) == 0 || len(rule.Ports) == 0 {
+		if len(rule.To) == 0 {
 			continue
 		}
 
-		// Phase1: populate the clauses related to each individual rules.
-		podSelectorRules, err := podEgressRules(&rule, podNamespace)
-		if err != nil {
-			return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
-		}
-		transmitterRules = append(transmitterRules, podSelectorRules...)
+		for j, peer := range rule.To {
+			peerID := fmt.Sprintf("%s.to[%d]", rule.id, j)
+			peerRule := rule.NetworkPolicyEgressRule
+			peerRule.To = []networking.NetworkPolicyPeer{peer}
 
-		// Phase2: populate the clauses related to the namespace rules. (namepace selector...)
-		namespaceSelectorRules, err := namespaceEgressRules(&rule, podNamespace, allNamespaces)
-		if err != nil {
-			return nil, nil, fmt.Errorf("Error creating pod namespaceRule: %s", err)
+			// Phase0: populate the ACLs related to the IPBlock peers.
+			ipBlockRules, err := aclIPBlockEgressRules(peerRule)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod IPBlock ACLRules: %s", err)
+			}
+			ipRules = append(ipRules, aclsWithPolicyID(ipBlockRules, peerID)...)
+
+			// Phase1: populate the clauses related to each individual rules.
+			podSelectorRules, err := podEgressRules(&peerRule, podNamespace, allNamespaces, pods)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
+			}
+			transmitterRules = append(transmitterRules, rulesWithPolicyID(podSelectorRules, peerID)...)
+
+			// Phase2: populate the clauses related to the namespace rules. (namepace selector...)
+			namespaceSelectorRules, err := namespaceEgressRules(&peerRule, podNamespace, allNamespaces, selectedPodsPortResolver(pods))
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating pod namespaceRule: %s", err)
+			}
+			transmitterRules = append(transmitterRules, rulesWithPolicyID(namespaceSelectorRules, peerID)...)
 		}
+	}
 
-		transmitterRules = append(transmitterRules, namespaceSelectorRules...)
+	ipRules, err := exceptACLs(ipRules)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating pod IPBlock ACLRules: %s", err)
 	}
 
 	return transmitterRules, ipRules, nil
 }
 
+// rulesWithPolicyID sets the PolicyID of the rules.
+func rulesWithPolicyID(rules []policy.TagSelector, policyID string) []policy.TagSelector {
+	for _, rule := range rules {
+		rule.Policy.PolicyID = policyID
+	}
+	return rules
+}
+
+// aclsWithPolicyID sets the PolicyID of the ACLs.
+func aclsWithPolicyID(acls []policy.IPRule, policyID string) []policy.IPRule {
+	for _, acl := range acls {
+		acl.Policy.PolicyID = policyID
+	}
+	return acls
+}
+
 // namespaceRules generates all the rules associated with the matching of other namespaces
-func namespaceIngressRules(rule *networking.NetworkPolicyIngressRule, podNamespace string, allNamespaces *api.NamespaceList) ([]policy.TagSelector, error) {
+func namespaceIngressRules(rule *networking.NetworkPolicyIngressRule, podNamespace string, allNamespaces *api.NamespaceList, peerPorts peerPortResolver) ([]policy.TagSelector, error) {
 	receiverRules := []policy.TagSelector{}
 	matchedNamespaces := map[string]bool{}
 	for _, peer := range rule.From {
+		// Peers with a PodSelector are handled as pod rules.
+		if peer.NamespaceSelector == nil || peer.PodSelector != nil {
+			continue
+		}
+
 		// Individual From. Each From is ORed.
-		namespaceSelector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		namespaces, err := matchingNamespaces(peer.NamespaceSelector, allNamespaces)
 		if err != nil {
-			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			return nil, err
 		}
-		for _, namespace := range allNamespaces.Items {
-			if namespaceSelector.Matches(labels.Set(namespace.GetLabels())) {
-				matchedNamespaces[namespace.GetName()] = true
-			}
+		for _, namespace := range namespaces {
+			matchedNamespaces[namespace] = true
 		}
 	}
 
@@ -440,41 +976,48 @@ func namespaceIngressRules(rule *networking.NetworkPolicyIngressRule, podNamespa
 		}
 		allowedNamespaces = append(allowedNamespaces, namespace)
 	}
+	sort.Strings(allowedNamespaces)
 	// No need to add the Namespace clause if no namespaces were matched.
 	if len(allowedNamespaces) == 0 {
 		return nil, nil
 	}
-	clause := policy.KeyValueOperator{
-		Key:      UpstreamNamespaceIdentifier,
-		Operator: policy.Equal,
-		Value:    allowedNamespaces,
+
+	// Named ports are resolved against all the pods of the allowed namespaces.
+	var namedPorts namedPortResolver
+	if hasNamedPorts(rule.Ports) {
+		var err error
+		namedPorts, err = peerPorts(&metav1.LabelSelector{}, allowedNamespaces)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	selector := policy.TagSelector{
-		Clause: []policy.KeyValueOperator{clause},
-		Policy: &policy.FlowPolicy{
-			Action: policy.Accept,
-		},
+	portClauses, err := portSelector(rule.Ports, namedPorts)
+	if err != nil {
+		return nil, err
 	}
 
-	receiverRules = append(receiverRules, selector)
+	receiverRules = append(receiverRules, selectorRules(portClauses, namespaceSelector(allowedNamespaces...))...)
 	return receiverRules, nil
 }
 
 // namespaceRules generates all the rules associated with the matching of other namespaces
-func namespaceEgressRules(rule *networking.NetworkPolicyEgressRule, podNamespace string, allNamespaces *api.NamespaceList) ([]policy.TagSelector, error) {
+func namespaceEgressRules(rule *networking.NetworkPolicyEgressRule, podNamespace string, allNamespaces *api.NamespaceList, peerPorts peerPortResolver) ([]policy.TagSelector, error) {
 	receiverRules := []policy.TagSelector{}
 	matchedNamespaces := map[string]bool{}
 	for _, peer := range rule.To {
-		// Individual From. Each From is ORed.
-		namespaceSelector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		// Peers with a PodSelector are handled as pod rules.
+		if peer.NamespaceSelector == nil || peer.PodSelector != nil {
+			continue
+		}
+
+		// Individual To. Each To is ORed.
+		namespaces, err := matchingNamespaces(peer.NamespaceSelector, allNamespaces)
 		if err != nil {
-			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			return nil, err
 		}
-		for _, namespace := range allNamespaces.Items {
-			if namespaceSelector.Matches(labels.Set(namespace.GetLabels())) {
-				matchedNamespaces[namespace.GetName()] = true
-			}
+		for _, namespace := range namespaces {
+			matchedNamespaces[namespace] = true
 		}
 	}
 
@@ -486,40 +1029,52 @@ func namespaceEgressRules(rule *networking.NetworkPolicyEgressRule, podNamespace
 		}
 		allowedNamespaces = append(allowedNamespaces, namespace)
 	}
+	sort.Strings(allowedNamespaces)
 	// No need to add the Namespace clause if no namespaces were matched.
 	if len(allowedNamespaces) == 0 {
 		return nil, nil
 	}
-	clause := policy.KeyValueOperator{
-		Key:      UpstreamNamespaceIdentifier,
-		Operator: policy.Equal,
-		Value:    allowedNamespaces,
+
+	// Named ports are resolved against all the pods of the allowed namespaces.
+	var namedPorts namedPortResolver
+	if hasNamedPorts(rule.Ports) {
+		var err error
+		namedPorts, err = peerPorts(&metav1.LabelSelector{}, allowedNamespaces)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	selector := policy.TagSelector{
-		Clause: []policy.KeyValueOperator{clause},
-		Policy: &policy.FlowPolicy{
-			Action: policy.Accept,
-		},
+	portClauses, err := portSelector(rule.Ports, namedPorts)
+	if err != nil {
+		return nil, err
 	}
 
-	receiverRules = append(receiverRules, selector)
+	receiverRules = append(receiverRules, selectorRules(portClauses, namespaceSelector(allowedNamespaces...))...)
 	return receiverRules, nil
 }
 
-// generatePUPolicy creates a PUPolicy representation
-func generatePUPolicy(ingressKubeRules *[]networking.NetworkPolicyIngressRule, egressKubeRules *[]networking.NetworkPolicyEgressRule, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string) (*policy.PUPolicy, error) {
+// generatePUPolicy creates a PUPolicy representation based on the NetworkPolicies selecting the pod.
+// In audit mode, the flows rejected by the NetworkPolicies are accepted with the WouldDropPolicyID.
+func generatePUPolicy(policies []networking.NetworkPolicy, pod *api.Pod, allNamespaces *api.NamespaceList, pods podLister, mode EnforcementMode, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string) (*policy.PUPolicy, error) {
+
+	ingressKubeRules, egressKubeRules := isolationRules(policies)
 
-	ingressRulesList, ingressACLs, err := generateIngressRulesList(ingressKubeRules, podNamespace, allNamespaces)
+	ingressRulesList, ingressACLs, err := generateIngressRulesList(ingressKubeRules, pod, allNamespaces)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't generate ingress rules: %s", err)
 	}
 
-	egressRulesList, egressACLs, err := generateEgressRulesList(egressKubeRules, podNamespace, allNamespaces)
+	egressRulesList, egressACLs, err := generateEgressRulesList(egressKubeRules, pod.GetNamespace(), allNamespaces, pods)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't generate egress rules: %s", err)
 	}
 
+	if mode == EnforcementModeAudit {
+		ingressRulesList, ingressACLs = auditRules(ingressRulesList), auditACLs(ingressACLs)
+		egressRulesList, egressACLs = auditRules(egressRulesList), auditACLs(egressACLs)
+	}
+
 	excluded := []string{}
 
 	containerPolicy := policy.NewPUPolicy("", policy.Police, egressACLs, ingressACLs, egressRulesList, ingressRulesList, tags, tags, ips, triremeNets, excluded, nil, nil, nil, nil)
@@ -539,7 +1094,8 @@ func aclsAllowAll() []policy.IPRule {
 		Port:     "0:65535",
 		Protocol: "TCP",
 		Policy: &policy.FlowPolicy{
-			Action: policy.Accept,
+			Action:   policy.Accept,
+			PolicyID: AllowAllPolicyID,
 		},
 	}
 	iPruleUDP := policy.IPRule{
@@ -547,11 +1103,21 @@ func aclsAllowAll() []policy.IPRule {
 		Port:     "0:65535",
 		Protocol: "UDP",
 		Policy: &policy.FlowPolicy{
-			Action: policy.Accept,
+			Action:   policy.Accept,
+			PolicyID: AllowAllPolicyID,
+		},
+	}
+	iPruleSCTP := policy.IPRule{
+		Address:  "0.0.0.0/0",
+		Port:     "0:65535",
+		Protocol: string(api.ProtocolSCTP),
+		Policy: &policy.FlowPolicy{
+			Action:   policy.Accept,
+			PolicyID: AllowAllPolicyID,
 		},
 	}
 
-	return []policy.IPRule{iPruleTCP, iPruleUDP}
+	return []policy.IPRule{iPruleTCP, iPruleUDP, iPruleSCTP}
 }
 
 // rulesAllowAll generate the IPRules used as ACLs outside of Trireme cluster.
@@ -566,7 +1132,8 @@ func rulesAllowAll() []policy.TagSelector {
 	selector := policy.TagSelector{
 		Clause: completeClause,
 		Policy: &policy.FlowPolicy{
-			Action: policy.Accept,
+			Action:   policy.Accept,
+			PolicyID: AllowAllPolicyID,
 		},
 	}
 
@@ -607,7 +1174,7 @@ func logRules(containerPolicy *policy.PUPolicy) {
 	// INGRESS or RECEIVER or NETWORK Rules and ACLs.
 	for i, rule := range containerPolicy.ReceiverRules() {
 		for _, clause := range rule.Clause {
-			zap.L().Debug("Trireme receiver RULES for POD", zap.Int("i", i), zap.Any("selector", clause))
+			zap.L().Debug("Trireme receiver RULES for POD", zap.Int("i", i), zap.String("policyID", rule.Policy.PolicyID), zap.Any("selector", clause))
 		}
 	}
 	for i, acl := range containerPolicy.NetworkACLs() {
@@ -617,11 +1184,11 @@ func logRules(containerPolicy *policy.PUPolicy) {
 	// EGRESS or TRANSMITTER or APPLICATION Rules and ACLs.
 	for i, rule := range containerPolicy.TransmitterRules() {
 		for _, clause := range rule.Clause {
-			zap.L().Debug("Trireme transmitter RULES for POD", zap.Int("i", i), zap.Any("selector", clause))
+			zap.L().Debug("Trireme transmitter RULES for POD", zap.Int("i", i), zap.String("policyID", rule.Policy.PolicyID), zap.Any("selector", clause))
 		}
 	}
 	for i, acl := range containerPolicy.ApplicationACLs() {
-		zap.L().Debug("Trireme transmitter ACL for POD", zap.Int("i", i), zap.Any("Address", acl.Address), zap.Any("Port", acl.Port))
+		zap.L().Debug("Trireme transmitter ACL for POD", zap.Int("i", i), zap.Any("Address", acl.Address), zap.Any("Port", acl.Port), zap.String("policyID", acl.Policy.PolicyID))
 	}
 
 	// POD Tags.
@@ -0,0 +1,866 @@
+package resolver
+
+import (
+	"reflect"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testNamespaces() *api.NamespaceList {
+	return &api.NamespaceList{
+		Items: []api.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "frontend"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "payments"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "monitoring", Labels: map[string]string{"team": "ops"}}},
+		},
+	}
+}
+
+func testPods(namespace string) ([]api.Pod, error) {
+	pods := []api.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "payments", Labels: map[string]string{"app": "db"}},
+			Spec: api.PodSpec{
+				Containers: []api.Container{
+					{Ports: []api.ContainerPort{{Name: "sql", ContainerPort: 5432}}},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-1", Namespace: "billing", Labels: map[string]string{"app": "db"}},
+			Spec: api.PodSpec{
+				Containers: []api.Container{
+					{Ports: []api.ContainerPort{{Name: "sql", ContainerPort: 3306}, {Name: "dns", ContainerPort: 53, Protocol: api.ProtocolUDP}}},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "payments", Labels: map[string]string{"app": "web"}},
+			Spec: api.PodSpec{
+				Containers: []api.Container{
+					{Ports: []api.ContainerPort{{Name: "sql", ContainerPort: 8080}}},
+				},
+			},
+		},
+	}
+
+	namespacePods := []api.Pod{}
+	for _, pod := range pods {
+		if pod.GetNamespace() == namespace {
+			namespacePods = append(namespacePods, pod)
+		}
+	}
+	return namespacePods, nil
+}
+
+func findClause(clauses []policy.KeyValueOperator, key string) (policy.KeyValueOperator, bool) {
+	for _, clause := range clauses {
+		if clause.Key == key {
+			return clause, true
+		}
+	}
+	return policy.KeyValueOperator{}, false
+}
+
+var combinedPeerTests = []struct {
+	name               string
+	peer               networking.NetworkPolicyPeer
+	expectedRules      int
+	expectedNamespaces []string
+}{
+	{
+		name: "pod selector only",
+		peer: networking.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+		expectedRules:      1,
+		expectedNamespaces: []string{"default"},
+	},
+	{
+		name: "pod and namespace selector",
+		peer: networking.NetworkPolicyPeer{
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		},
+		expectedRules:      1,
+		expectedNamespaces: []string{"billing", "payments"},
+	},
+	{
+		name: "pod and namespace selector without matching namespace",
+		peer: networking.NetworkPolicyPeer{
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "unknown"}},
+		},
+		expectedRules: 0,
+	},
+	{
+		name: "namespace selector only",
+		peer: networking.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		},
+		expectedRules: 0,
+	},
+}
+
+func checkCombinedPeerRules(t *testing.T, name string, rules []policy.TagSelector, expectedRules int, expectedNamespaces []string) {
+	if len(rules) != expectedRules {
+		t.Fatalf("%s: got %d rules, expected %d", name, len(rules), expectedRules)
+	}
+	if expectedRules == 0 {
+		return
+	}
+
+	namespaceClause, ok := findClause(rules[0].Clause, UpstreamNamespaceIdentifier)
+	if !ok {
+		t.Fatalf("%s: no namespace clause in %v", name, rules[0].Clause)
+	}
+	if !reflect.DeepEqual(namespaceClause.Value, expectedNamespaces) {
+		t.Errorf("%s: namespace clause is %v, expected %v", name, namespaceClause.Value, expectedNamespaces)
+	}
+
+	podClause, ok := findClause(rules[0].Clause, "app")
+	if !ok {
+		t.Fatalf("%s: no pod label clause in %v", name, rules[0].Clause)
+	}
+	if !reflect.DeepEqual(podClause.Value, []string{"db"}) {
+		t.Errorf("%s: pod label clause is %v, expected [db]", name, podClause.Value)
+	}
+}
+
+func TestPodIngressRulesCombinedPeers(t *testing.T) {
+	for _, tt := range combinedPeerTests {
+		rule := &networking.NetworkPolicyIngressRule{
+			From: []networking.NetworkPolicyPeer{tt.peer},
+		}
+		pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default"}}
+		rules, err := podIngressRules(rule, pod, testNamespaces())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		checkCombinedPeerRules(t, tt.name, rules, tt.expectedRules, tt.expectedNamespaces)
+	}
+}
+
+func TestPodEgressRulesCombinedPeers(t *testing.T) {
+	for _, tt := range combinedPeerTests {
+		rule := &networking.NetworkPolicyEgressRule{
+			To: []networking.NetworkPolicyPeer{tt.peer},
+		}
+		rules, err := podEgressRules(rule, "default", testNamespaces(), testPods)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		checkCombinedPeerRules(t, tt.name, rules, tt.expectedRules, tt.expectedNamespaces)
+	}
+}
+
+func TestNamespaceRulesIgnoreCombinedPeers(t *testing.T) {
+	peers := []networking.NetworkPolicyPeer{
+		{
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		},
+	}
+
+	ingressRules, err := namespaceIngressRules(&networking.NetworkPolicyIngressRule{From: peers}, "default", testNamespaces(), selectedPodsPortResolver(testPods))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(ingressRules) != 0 {
+		t.Errorf("combined peer generated ingress namespace rules %v", ingressRules)
+	}
+
+	egressRules, err := namespaceEgressRules(&networking.NetworkPolicyEgressRule{To: peers}, "default", testNamespaces(), selectedPodsPortResolver(testPods))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(egressRules) != 0 {
+		t.Errorf("combined peer generated egress namespace rules %v", egressRules)
+	}
+}
+
+func TestNamedPorts(t *testing.T) {
+	udp := api.ProtocolUDP
+	sqlPort := intstr.FromString("sql")
+	dnsPort := intstr.FromString("dns")
+	unknownPort := intstr.FromString("unknown")
+	numericPort := intstr.FromInt(443)
+
+	tests := []struct {
+		name          string
+		ports         []networking.NetworkPolicyPort
+		expectedPorts []string
+		expectedMatch bool
+	}{
+		{
+			name:          "named port resolved on all selected pods",
+			ports:         []networking.NetworkPolicyPort{{Port: &sqlPort}},
+			expectedPorts: []string{"3306", "5432"},
+			expectedMatch: true,
+		},
+		{
+			name:          "named port with protocol",
+			ports:         []networking.NetworkPolicyPort{{Port: &dnsPort, Protocol: &udp}},
+			expectedPorts: []string{"53"},
+			expectedMatch: true,
+		},
+		{
+			name:          "named port with the wrong protocol",
+			ports:         []networking.NetworkPolicyPort{{Port: &dnsPort}},
+			expectedMatch: false,
+		},
+		{
+			name:          "unknown named port mixed with numeric port",
+			ports:         []networking.NetworkPolicyPort{{Port: &unknownPort}, {Port: &numericPort}},
+			expectedPorts: []string{"443"},
+			expectedMatch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		rule := &networking.NetworkPolicyEgressRule{
+			Ports: tt.ports,
+			To: []networking.NetworkPolicyPeer{
+				{
+					PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+				},
+			},
+		}
+		rules, err := podEgressRules(rule, "default", testNamespaces(), testPods)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		if !tt.expectedMatch {
+			if len(rules) != 0 {
+				t.Errorf("%s: expected no rules, got %v", tt.name, rules)
+			}
+			continue
+		}
+		if len(rules) != 1 {
+			t.Fatalf("%s: expected 1 rule, got %v", tt.name, rules)
+		}
+		portClause, ok := findClause(rules[0].Clause, PortIdentifier)
+		if !ok {
+			t.Fatalf("%s: no port clause in %v", tt.name, rules[0].Clause)
+		}
+		if !reflect.DeepEqual(portClause.Value, tt.expectedPorts) {
+			t.Errorf("%s: port clause is %v, expected %v", tt.name, portClause.Value, tt.expectedPorts)
+		}
+	}
+}
+
+func TestNamedPortsIngress(t *testing.T) {
+	httpPort := intstr.FromString("http")
+	pod := &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default"},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				{Ports: []api.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+			},
+		},
+	}
+	rule := &networking.NetworkPolicyIngressRule{
+		Ports: []networking.NetworkPolicyPort{{Port: &httpPort}},
+		From: []networking.NetworkPolicyPeer{
+			{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}},
+		},
+	}
+
+	rules, err := podIngressRules(rule, pod, testNamespaces())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 rule, got %v", rules)
+	}
+	portClause, ok := findClause(rules[0].Clause, PortIdentifier)
+	if !ok || !reflect.DeepEqual(portClause.Value, []string{"8080"}) {
+		t.Errorf("port clause is %v, expected [8080]", portClause.Value)
+	}
+}
+
+// rulesMatch returns true if any rule matches the tags of a flow, with the semantics of the Trireme
+// policy lookup: the clauses of a rule are ANDed, and the values of a clause are ORed.
+func rulesMatch(rules []policy.TagSelector, tags map[string]string) bool {
+	for _, rule := range rules {
+		matches := true
+		for _, clause := range rule.Clause {
+			value, ok := tags[clause.Key]
+			switch clause.Operator {
+			case policy.Equal:
+				matches = ok && containsString(clause.Value, value)
+			case policy.NotEqual:
+				matches = !ok || !containsString(clause.Value, value)
+			case policy.KeyExists:
+				matches = ok
+			case policy.KeyNotExists:
+				matches = !ok
+			}
+			if !matches {
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPortsOfAllProtocols(t *testing.T) {
+	tcp := api.ProtocolTCP
+	udp := api.ProtocolUDP
+	sctp := api.ProtocolSCTP
+	dnsPort := intstr.FromInt(53)
+	webPort := intstr.FromInt(80)
+
+	tests := []struct {
+		name  string
+		ports []networking.NetworkPolicyPort
+		// allowed and rejected are destination ports of flows from an allowed pod.
+		allowed  []string
+		rejected []string
+	}{
+		{
+			name: "ports of several protocols",
+			ports: []networking.NetworkPolicyPort{
+				{Port: &dnsPort, Protocol: &tcp},
+				{Port: &dnsPort, Protocol: &udp},
+				{Port: &webPort},
+			},
+			allowed:  []string{"53", "80"},
+			rejected: []string{"443"},
+		},
+		{
+			name: "all the ports of a protocol",
+			ports: []networking.NetworkPolicyPort{
+				{Port: &webPort, Protocol: &tcp},
+				{Protocol: &sctp},
+			},
+			allowed: []string{"80", "443"},
+		},
+		{
+			name:    "no ports",
+			allowed: []string{"80", "443"},
+		},
+	}
+
+	for _, tt := range tests {
+		rule := &networking.NetworkPolicyIngressRule{
+			Ports: tt.ports,
+			From: []networking.NetworkPolicyPeer{
+				{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}},
+			},
+		}
+		pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default"}}
+
+		rules, err := podIngressRules(rule, pod, testNamespaces())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+
+		for _, port := range tt.allowed {
+			flow := map[string]string{UpstreamNamespaceIdentifier: "default", "app": "client", PortIdentifier: port}
+			if !rulesMatch(rules, flow) {
+				t.Errorf("%s: expected port %s to be allowed by %v", tt.name, port, rules)
+			}
+			// The flows of the pods that are not selected are never allowed.
+			flow["app"] = "other"
+			if rulesMatch(rules, flow) {
+				t.Errorf("%s: expected port %s of an other pod to be rejected by %v", tt.name, port, rules)
+			}
+		}
+		for _, port := range tt.rejected {
+			flow := map[string]string{UpstreamNamespaceIdentifier: "default", "app": "client", PortIdentifier: port}
+			if rulesMatch(rules, flow) {
+				t.Errorf("%s: expected port %s to be rejected by %v", tt.name, port, rules)
+			}
+		}
+	}
+}
+
+func TestPortSelectorPortRange(t *testing.T) {
+	udp := api.ProtocolUDP
+	port80 := intstr.FromInt(80)
+	port30000 := intstr.FromInt(30000)
+	endPort := int32(32767)
+	namedHTTP := intstr.FromString("http")
+
+	ports := []networking.NetworkPolicyPort{{Port: &port30000, EndPort: &endPort, Protocol: &udp}, {Port: &port80}}
+	clauses, err := portSelector(ports, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expected := [][]policy.KeyValueOperator{
+		{{Key: PortIdentifier, Operator: policy.Equal, Value: []string{"30000:32767", "80"}}},
+	}
+	if !reflect.DeepEqual(clauses, expected) {
+		t.Errorf("got clauses %v, expected %v", clauses, expected)
+	}
+
+	if _, err := portSelector([]networking.NetworkPolicyPort{{Port: &namedHTTP, EndPort: &endPort}}, nil); err == nil {
+		t.Errorf("expected an error for a port range with a named port")
+	}
+}
+
+type expectedACL struct {
+	address  string
+	port     string
+	protocol string
+	action   policy.ActionType
+}
+
+func checkACLs(t *testing.T, name string, acls []policy.IPRule, expected []expectedACL) {
+	if len(acls) != len(expected) {
+		t.Errorf("%s: got %d ACLs %v, expected %v", name, len(acls), acls, expected)
+		return
+	}
+	for i, acl := range acls {
+		got := expectedACL{address: acl.Address, port: acl.Port, protocol: acl.Protocol, action: acl.Policy.Action}
+		if got != expected[i] {
+			t.Errorf("%s: ACL %d is %v, expected %v", name, i, got, expected[i])
+		}
+	}
+}
+
+func TestACLIngressRules(t *testing.T) {
+	tcp := api.ProtocolTCP
+	udp := api.ProtocolUDP
+	sctp := api.ProtocolSCTP
+	unknown := api.Protocol("ICMP")
+	port53 := intstr.FromInt(53)
+	port80 := intstr.FromInt(80)
+	port81 := intstr.FromInt(81)
+	port30000 := intstr.FromInt(30000)
+	endPort := int32(32767)
+	lowEndPort := int32(1000)
+	namedHTTP := intstr.FromString("http")
+	namedMissing := intstr.FromString("missing")
+
+	pod := &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default"},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				{Ports: []api.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		ports       []networking.NetworkPolicyPort
+		expected    []expectedACL
+		expectError bool
+	}{
+		{
+			name: "allow all ports",
+			expected: []expectedACL{
+				{"0.0.0.0/0", "0:65535", "TCP", policy.Accept},
+				{"0.0.0.0/0", "0:65535", "UDP", policy.Accept},
+				{"0.0.0.0/0", "0:65535", "SCTP", policy.Accept},
+			},
+		},
+		{
+			name:  "port without protocol defaults to TCP",
+			ports: []networking.NetworkPolicyPort{{Port: &port80}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "80", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "multiple ports on the same protocol",
+			ports: []networking.NetworkPolicyPort{{Port: &port80, Protocol: &tcp}, {Port: &port81, Protocol: &tcp}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "80", "TCP", policy.Accept},
+				{"0.0.0.0/0", "81", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "mixed protocols on the same port",
+			ports: []networking.NetworkPolicyPort{{Port: &port53, Protocol: &udp}, {Port: &port53, Protocol: &tcp}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "53", "UDP", policy.Accept},
+				{"0.0.0.0/0", "53", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "SCTP port",
+			ports: []networking.NetworkPolicyPort{{Port: &port80, Protocol: &sctp}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "80", "SCTP", policy.Accept},
+			},
+		},
+		{
+			name:  "protocol without port matches all ports of the protocol",
+			ports: []networking.NetworkPolicyPort{{Protocol: &udp}, {Port: &port53, Protocol: &udp}, {Port: &port80}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "0:65535", "UDP", policy.Accept},
+				{"0.0.0.0/0", "80", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "duplicate ports",
+			ports: []networking.NetworkPolicyPort{{Port: &port80}, {Port: &port80, Protocol: &tcp}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "80", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "named port",
+			ports: []networking.NetworkPolicyPort{{Port: &namedHTTP}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "8080", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:     "unresolved named port",
+			ports:    []networking.NetworkPolicyPort{{Port: &namedMissing}},
+			expected: []expectedACL{},
+		},
+		{
+			name:  "port range",
+			ports: []networking.NetworkPolicyPort{{Port: &port30000, EndPort: &endPort, Protocol: &udp}, {Port: &port80}},
+			expected: []expectedACL{
+				{"0.0.0.0/0", "30000:32767", "UDP", policy.Accept},
+				{"0.0.0.0/0", "80", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:        "port range with a named port",
+			ports:       []networking.NetworkPolicyPort{{Port: &namedHTTP, EndPort: &endPort}},
+			expectError: true,
+		},
+		{
+			name:        "port range ending before its port",
+			ports:       []networking.NetworkPolicyPort{{Port: &port30000, EndPort: &lowEndPort}},
+			expectError: true,
+		},
+		{
+			name:        "unknown protocol",
+			ports:       []networking.NetworkPolicyPort{{Port: &port80, Protocol: &unknown}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		rule := networking.NetworkPolicyIngressRule{Ports: tt.ports}
+		acls, err := aclIngressRules(rule, containerPortResolver([]api.Pod{*pod}))
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+		checkACLs(t, tt.name, acls, tt.expected)
+	}
+}
+
+func TestACLEgressRulesIgnoreNamedPorts(t *testing.T) {
+	namedHTTP := intstr.FromString("http")
+	port443 := intstr.FromInt(443)
+
+	rule := networking.NetworkPolicyEgressRule{
+		Ports: []networking.NetworkPolicyPort{{Port: &namedHTTP}, {Port: &port443}},
+	}
+	acls, err := aclEgressRules(rule)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	checkACLs(t, "egress named port", acls, []expectedACL{
+		{"0.0.0.0/0", "443", "TCP", policy.Accept},
+	})
+}
+
+func TestIPBlockACLs(t *testing.T) {
+	port443 := intstr.FromInt(443)
+	namedHTTP := intstr.FromString("http")
+
+	pod := &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default"},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				{Ports: []api.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		ports           []networking.NetworkPolicyPort
+		peers           []networking.NetworkPolicyPeer
+		expectedIngress []expectedACL
+		expectedEgress  []expectedACL
+		expectError     bool
+	}{
+		{
+			name:  "cidr without except",
+			ports: []networking.NetworkPolicyPort{{Port: &port443}},
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/16"}},
+			},
+			expectedIngress: []expectedACL{
+				{"10.20.0.0/16", "443", "TCP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{
+				{"10.20.0.0/16", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name: "cidr without ports matches all the protocols",
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/16"}},
+			},
+			expectedIngress: []expectedACL{
+				{"10.20.0.0/16", "0:65535", "TCP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "UDP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "SCTP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{
+				{"10.20.0.0/16", "0:65535", "TCP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "UDP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "SCTP", policy.Accept},
+			},
+		},
+		{
+			name:  "except is rejected before the cidr",
+			ports: []networking.NetworkPolicyPort{{Port: &port443}},
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/22", Except: []string{"10.20.1.0/24"}}},
+			},
+			expectedIngress: []expectedACL{
+				{"10.20.1.0/24", "443", "TCP", policy.Reject},
+				{"10.20.0.0/22", "443", "TCP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{
+				{"10.20.1.0/24", "443", "TCP", policy.Reject},
+				{"10.20.0.0/22", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "multiple excepts",
+			ports: []networking.NetworkPolicyPort{{Port: &port443}},
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/22", Except: []string{"10.20.1.0/24", "10.20.2.0/23"}}},
+			},
+			expectedIngress: []expectedACL{
+				{"10.20.1.0/24", "443", "TCP", policy.Reject},
+				{"10.20.2.0/23", "443", "TCP", policy.Reject},
+				{"10.20.0.0/22", "443", "TCP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{
+				{"10.20.1.0/24", "443", "TCP", policy.Reject},
+				{"10.20.2.0/23", "443", "TCP", policy.Reject},
+				{"10.20.0.0/22", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "except covering the cidr",
+			ports: []networking.NetworkPolicyPort{{Port: &port443}},
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/24", Except: []string{"10.20.0.0/24"}}},
+			},
+			expectedIngress: []expectedACL{
+				{"10.20.0.0/24", "443", "TCP", policy.Reject},
+				{"10.20.0.0/24", "443", "TCP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{
+				{"10.20.0.0/24", "443", "TCP", policy.Reject},
+				{"10.20.0.0/24", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "IPv6 cidr",
+			ports: []networking.NetworkPolicyPort{{Port: &port443}},
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "fd00::/63", Except: []string{"fd00::/64"}}},
+			},
+			expectedIngress: []expectedACL{
+				{"fd00::/64", "443", "TCP", policy.Reject},
+				{"fd00::/63", "443", "TCP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{
+				{"fd00::/64", "443", "TCP", policy.Reject},
+				{"fd00::/63", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name:  "named port is only resolved for ingress",
+			ports: []networking.NetworkPolicyPort{{Port: &namedHTTP}},
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/16"}},
+			},
+			expectedIngress: []expectedACL{
+				{"10.20.0.0/16", "8080", "TCP", policy.Accept},
+			},
+			expectedEgress: []expectedACL{},
+		},
+		{
+			name: "peers without IPBlock are ignored",
+			peers: []networking.NetworkPolicyPeer{
+				{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}},
+			},
+			expectedIngress: []expectedACL{},
+			expectedEgress:  []expectedACL{},
+		},
+		{
+			name: "except outside of the cidr",
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/16", Except: []string{"192.168.0.0/24"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "except larger than the cidr",
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/16", Except: []string{"10.20.1.0/8"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid cidr",
+			peers: []networking.NetworkPolicyPeer{
+				{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0"}},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		ingressACLs, ingressErr := aclIPBlockIngressRules(networking.NetworkPolicyIngressRule{Ports: tt.ports, From: tt.peers}, containerPortResolver([]api.Pod{*pod}))
+		egressACLs, egressErr := aclIPBlockEgressRules(networking.NetworkPolicyEgressRule{Ports: tt.ports, To: tt.peers})
+		if tt.expectError {
+			if ingressErr == nil || egressErr == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if ingressErr != nil || egressErr != nil {
+			t.Errorf("%s: unexpected errors %v %v", tt.name, ingressErr, egressErr)
+			continue
+		}
+		checkACLs(t, tt.name+" ingress", ingressACLs, tt.expectedIngress)
+		checkACLs(t, tt.name+" egress", egressACLs, tt.expectedEgress)
+	}
+}
+
+func TestIPBlockExceptDoesNotOverrideOtherRules(t *testing.T) {
+	port443 := intstr.FromInt(443)
+	except := &networking.IPBlock{CIDR: "10.20.0.0/16", Except: []string{"10.20.1.0/24"}}
+
+	type testRule struct {
+		ports []networking.NetworkPolicyPort
+		peers []networking.NetworkPolicyPeer
+	}
+
+	tests := []struct {
+		name     string
+		rules    []testRule
+		expected []expectedACL
+	}{
+		{
+			name: "rule without peers accepts the except range",
+			rules: []testRule{
+				{peers: []networking.NetworkPolicyPeer{{IPBlock: except}}},
+				{},
+			},
+			expected: []expectedACL{
+				{"10.20.0.0/16", "0:65535", "TCP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "UDP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "SCTP", policy.Accept},
+				{"0.0.0.0/0", "0:65535", "TCP", policy.Accept},
+				{"0.0.0.0/0", "0:65535", "UDP", policy.Accept},
+				{"0.0.0.0/0", "0:65535", "SCTP", policy.Accept},
+			},
+		},
+		{
+			name: "other rule accepts a port of a part of the except range",
+			rules: []testRule{
+				{peers: []networking.NetworkPolicyPeer{{IPBlock: except}}},
+				{
+					ports: []networking.NetworkPolicyPort{{Port: &port443}},
+					peers: []networking.NetworkPolicyPeer{{IPBlock: &networking.IPBlock{CIDR: "10.20.1.128/25"}}},
+				},
+			},
+			expected: []expectedACL{
+				{"10.20.1.0/25", "0:65535", "TCP", policy.Reject},
+				{"10.20.1.128/25", "0:442", "TCP", policy.Reject},
+				{"10.20.1.128/25", "444:65535", "TCP", policy.Reject},
+				{"10.20.1.0/24", "0:65535", "UDP", policy.Reject},
+				{"10.20.1.0/24", "0:65535", "SCTP", policy.Reject},
+				{"10.20.0.0/16", "0:65535", "TCP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "UDP", policy.Accept},
+				{"10.20.0.0/16", "0:65535", "SCTP", policy.Accept},
+				{"10.20.1.128/25", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name: "other peer of the same rule accepts the except range",
+			rules: []testRule{
+				{
+					ports: []networking.NetworkPolicyPort{{Port: &port443}},
+					peers: []networking.NetworkPolicyPeer{
+						{IPBlock: except},
+						{IPBlock: &networking.IPBlock{CIDR: "10.20.1.0/24"}},
+					},
+				},
+			},
+			expected: []expectedACL{
+				{"10.20.0.0/16", "443", "TCP", policy.Accept},
+				{"10.20.1.0/24", "443", "TCP", policy.Accept},
+			},
+		},
+		{
+			name: "except range of both rules is rejected",
+			rules: []testRule{
+				{
+					ports: []networking.NetworkPolicyPort{{Port: &port443}},
+					peers: []networking.NetworkPolicyPeer{{IPBlock: except}},
+				},
+				{
+					ports: []networking.NetworkPolicyPort{{Port: &port443}},
+					peers: []networking.NetworkPolicyPeer{{IPBlock: &networking.IPBlock{CIDR: "10.20.0.0/20", Except: []string{"10.20.1.0/24"}}}},
+				},
+			},
+			expected: []expectedACL{
+				{"10.20.1.0/24", "443", "TCP", policy.Reject},
+				{"10.20.1.0/24", "443", "TCP", policy.Reject},
+				{"10.20.0.0/16", "443", "TCP", policy.Accept},
+				{"10.20.0.0/20", "443", "TCP", policy.Accept},
+			},
+		},
+	}
+
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "default", Labels: map[string]string{"app": "server"}}}
+	for _, tt := range tests {
+		ingress := []networking.NetworkPolicyIngressRule{}
+		egress := []networking.NetworkPolicyEgressRule{}
+		for _, rule := range tt.rules {
+			ingress = append(ingress, networking.NetworkPolicyIngressRule{Ports: rule.ports, From: rule.peers})
+			egress = append(egress, networking.NetworkPolicyEgressRule{Ports: rule.ports, To: rule.peers})
+		}
+
+		ingressPolicy, err := generatePUPolicy([]networking.NetworkPolicy{testPolicy("p", ingressOnly, ingress, nil)}, pod, testNamespaces(), testPods, EnforcementModeEnforce, policy.NewTagStore(), policy.ExtendedMap{}, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		checkACLs(t, tt.name+" ingress", ingressPolicy.NetworkACLs(), tt.expected)
+
+		egressPolicy, err := generatePUPolicy([]networking.NetworkPolicy{testPolicy("p", egressOnly, nil, egress)}, pod, testNamespaces(), testPods, EnforcementModeEnforce, policy.NewTagStore(), policy.ExtendedMap{}, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		checkACLs(t, tt.name+" egress", egressPolicy.ApplicationACLs(), tt.expected)
+	}
+}